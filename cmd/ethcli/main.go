@@ -1,6 +1,12 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/buildwithme/ethparser/internal/blockfetch"
 	"github.com/buildwithme/ethparser/internal/parser"
 	"github.com/buildwithme/ethparser/internal/rpcfetch"
@@ -12,20 +18,41 @@ import (
 func main() {
 	logger := logger.NewLogger()
 
+	if err := run(logger); err != nil {
+		logger.Printf("[FATAL] %v", err)
+		os.Exit(1)
+	}
+}
+
+// run fetches blocks until SIGINT/SIGTERM or the end block, then flushes
+// storage.
+func run(logger *logger.Logger) error {
 	// Parse CLI flags & override .env if needed
 	cf := ParseFlags()
 
 	cf.ApplyEnvFile()
 
 	if err := env.LoadDotEnv(); err != nil {
-		logger.Fatalf("[FATAL] .env not loaded: %v", err)
+		return fmt.Errorf(".env not loaded: %w", err)
 	}
 
 	// Apply any CLI flag overrides to the environment
 	cf.ApplyConfig()
 
-	storage := storage.NewMemoryStorage()
-	rpcFetcher := rpcfetch.NewFetcher(logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	storage, err := storage.New()
+	if err != nil {
+		return fmt.Errorf("storage not opened: %w", err)
+	}
+	defer func() {
+		if err := storage.Close(); err != nil {
+			logger.Printf("[ERROR] Failed to flush storage: %v", err)
+		}
+	}()
+
+	rpcFetcher := rpcfetch.NewFetcher(ctx, logger)
 	blockFetcher := blockfetch.NewFetcher(logger, storage, rpcFetcher)
 	parser := parser.NewParser(logger, storage, blockFetcher)
 
@@ -33,5 +60,9 @@ func main() {
 	subscribeEnvAddresses(parser, logger)
 
 	// Fetch blocks
-	blockFetcher.Run()
+	if err := blockFetcher.Run(ctx); err != nil {
+		return fmt.Errorf("block fetcher: %w", err)
+	}
+
+	return nil
 }
@@ -11,15 +11,22 @@ import (
 
 // ConfigFlags holds possible command-line overrides.
 type ConfigFlags struct {
-	EnvFile     string
-	Addresses   string
-	RPCEndpoint string
-	Port        int
-	Concurrency int
-	ChunkSize   int
-	MaxRetries  int
-	StartBlock  int
-	EndBlock    int
+	EnvFile        string
+	Addresses      string
+	RPCEndpoint    string
+	Port           int
+	Concurrency    int
+	MaxConcurrency int
+	ChunkSize      int
+	MaxRetries     int
+	BatchSize      int
+	Receipts       bool
+	Traces         bool
+	StartBlock     int
+	EndBlock       int
+	Storage        string
+	StoragePath    string
+	Confirmations  int
 }
 
 // ParseFlags parses CLI flags and returns them in ConfigFlags.
@@ -32,10 +39,17 @@ func ParseFlags() ConfigFlags {
 	flag.StringVar(&cf.RPCEndpoint, "rpc", "", "Override the RPC_ENDPOINT env var (default from .env).")
 	flag.IntVar(&cf.Port, "port", 0, "Override the PORT env var (default from .env).")
 	flag.IntVar(&cf.Concurrency, "concurrency", 0, "Override the CONCURRENCY env var (default from .env).")
+	flag.IntVar(&cf.MaxConcurrency, "max-concurrency", 0, "Override the MAX_CONCURRENCY env var (default from .env).")
 	flag.IntVar(&cf.ChunkSize, "chunk-size", 0, "Override the CHUNK_SIZE env var (default from .env).")
 	flag.IntVar(&cf.MaxRetries, "max-retries", 0, "Override the MAX_RETRIES env var (default from .env).")
+	flag.IntVar(&cf.BatchSize, "batch-size", 0, "Override the BATCH_SIZE env var (default from .env).")
+	flag.BoolVar(&cf.Receipts, "receipts", false, "Fetch transaction receipts, overriding the FETCH_RECEIPTS env var.")
+	flag.BoolVar(&cf.Traces, "traces", false, "Trace internal transactions, overriding the FETCH_TRACES env var.")
 	flag.IntVar(&cf.StartBlock, "start", 0, "Override the DEFAULT_START_BLOCK env var (default from .env).")
 	flag.IntVar(&cf.EndBlock, "end", 0, "Override the DEFAULT_END_BLOCK env var (default from .env).")
+	flag.StringVar(&cf.Storage, "storage", "", "Override the STORAGE env var: memory or file (default from .env).")
+	flag.StringVar(&cf.StoragePath, "storage-path", "", "Override the STORAGE_PATH env var (default from .env).")
+	flag.IntVar(&cf.Confirmations, "confirmations", 0, "Override the CONFIRMATIONS env var (default from .env).")
 
 	// Customize usage help if desired:
 	flag.Usage = func() {
@@ -74,6 +88,10 @@ func (cf ConfigFlags) ApplyConfig() {
 		os.Setenv(constants.ENV_CONCURRENCY, strconv.Itoa(cf.Concurrency))
 	}
 
+	if cf.MaxConcurrency > 0 {
+		os.Setenv(constants.ENV_MAX_CONCURRENCY, strconv.Itoa(cf.MaxConcurrency))
+	}
+
 	if cf.ChunkSize > 0 {
 		os.Setenv(constants.ENV_CHUNK_SIZE, strconv.Itoa(cf.ChunkSize))
 	}
@@ -82,6 +100,18 @@ func (cf ConfigFlags) ApplyConfig() {
 		os.Setenv(constants.ENV_MAX_RETRIES, strconv.Itoa(cf.MaxRetries))
 	}
 
+	if cf.BatchSize > 0 {
+		os.Setenv(constants.ENV_BATCH_SIZE, strconv.Itoa(cf.BatchSize))
+	}
+
+	if cf.Receipts {
+		os.Setenv(constants.ENV_FETCH_RECEIPTS, "true")
+	}
+
+	if cf.Traces {
+		os.Setenv(constants.ENV_FETCH_TRACES, "true")
+	}
+
 	if cf.StartBlock > 0 {
 		os.Setenv(constants.ENV_DEFAULT_START_BLOCK, strconv.Itoa(cf.StartBlock))
 	}
@@ -89,4 +119,16 @@ func (cf ConfigFlags) ApplyConfig() {
 	if cf.EndBlock > 0 {
 		os.Setenv(constants.ENV_DEFAULT_END_BLOCK, strconv.Itoa(cf.EndBlock))
 	}
+
+	if cf.Storage != "" {
+		os.Setenv(constants.ENV_STORAGE, cf.Storage)
+	}
+
+	if cf.StoragePath != "" {
+		os.Setenv(constants.ENV_STORAGE_PATH, cf.StoragePath)
+	}
+
+	if cf.Confirmations > 0 {
+		os.Setenv(constants.ENV_CONFIRMATIONS, strconv.Itoa(cf.Confirmations))
+	}
 }
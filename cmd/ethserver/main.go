@@ -1,52 +1,147 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/buildwithme/ethparser/internal/blockfetch"
 	"github.com/buildwithme/ethparser/internal/httphandlers"
 	"github.com/buildwithme/ethparser/internal/parser"
 	"github.com/buildwithme/ethparser/internal/rpcfetch"
 	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/internal/webhook"
 	"github.com/buildwithme/ethparser/pkg/constants"
 	"github.com/buildwithme/ethparser/pkg/env"
 	"github.com/buildwithme/ethparser/pkg/logger"
 )
 
+// SHUTDOWN_TIMEOUT bounds how long in-flight HTTP requests may drain.
+const SHUTDOWN_TIMEOUT = 10 * time.Second
+
 func main() {
 	logger := logger.NewLogger()
 
+	if err := run(logger); err != nil {
+		logger.Printf("[FATAL] %v", err)
+		os.Exit(1)
+	}
+}
+
+// run serves until SIGINT/SIGTERM or a fatal error, then stops block
+// fetching, drains HTTP connections and flushes storage.
+func run(logger *logger.Logger) error {
 	// Parse CLI flags & override .env if needed
 	cf := ParseFlags()
 
 	cf.ApplyEnvFile()
 
 	if err := env.LoadDotEnv(); err != nil {
-		logger.Fatalf("[FATAL] .env not loaded: %v", err)
+		return fmt.Errorf(".env not loaded: %w", err)
 	}
 
 	// Apply any CLI flag overrides to the environment
 	cf.ApplyConfig()
 
-	storage := storage.NewMemoryStorage()
-	rpcFetcher := rpcfetch.NewFetcher(logger)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	storage, err := storage.New()
+	if err != nil {
+		return fmt.Errorf("storage not opened: %w", err)
+	}
+	defer func() {
+		if err := storage.Close(); err != nil {
+			logger.Printf("[ERROR] Failed to flush storage: %v", err)
+		}
+	}()
+
+	// Queue webhook deliveries of every stored block
+	notifier, err := webhook.New(logger)
+	if err != nil {
+		return fmt.Errorf("webhook queue not opened: %w", err)
+	}
+	defer notifier.Close()
+	storage = webhook.Wrap(storage, notifier)
+
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		notifier.Run(ctx)
+	}()
+
+	rpcFetcher := rpcfetch.NewFetcher(ctx, logger)
 	blockFetcher := blockfetch.NewFetcher(logger, storage, rpcFetcher)
 	parser := parser.NewParser(logger, storage, blockFetcher)
 
 	// Fetch blocks
-	go blockFetcher.Run()
+	fetchErr := make(chan error, 1)
+	go func() {
+		fetchErr <- blockFetcher.Run(ctx)
+	}()
 
 	// Register HTTP handlers
-	handlers := httphandlers.New(parser)
+	handlers := httphandlers.New(parser, rpcFetcher, notifier)
 	handlers.RegisterHandlers()
 
 	endpoint := fmt.Sprintf(":%s", env.GetEnvString(constants.ENV_PORT, "8080"))
+	server := &http.Server{
+		Addr: endpoint,
+		// Streams and WebSocket sessions end with the shutdown signal
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Printf("[INFO]: HTTP server starting on %s", endpoint)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	// Wait for a signal or a fatal error. The fetcher stopping on its own
+	// (end block reached) leaves the API up.
+	var runErr error
+	fetching := true
+	for runErr == nil && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+			logger.Printf("[INFO] Shutting down")
+		case err := <-serveErr:
+			if !errors.Is(err, http.ErrServerClosed) {
+				runErr = fmt.Errorf("server error: %w", err)
+			}
+		case err := <-fetchErr:
+			fetching = false
+			if err != nil {
+				runErr = fmt.Errorf("block fetcher: %w", err)
+			}
+		}
+	}
+	stop()
 
-	logger.Printf("[INFO]: HTTP server starting on %s", endpoint)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
 
-	err := http.ListenAndServe(endpoint, nil)
-	if err != nil && err != http.ErrServerClosed {
-		logger.Fatalf("server error: %v", err)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Printf("[WARN] HTTP server did not drain: %v", err)
 	}
+
+	// The in-flight chunk and backfills finish or abort before storage closes
+	if fetching {
+		if err := <-fetchErr; err != nil && runErr == nil {
+			runErr = fmt.Errorf("block fetcher: %w", err)
+		}
+	}
+	workers.Wait()
+
+	logger.Printf("[INFO] Stopped. Last processed = %d", blockFetcher.GetCurrentBlock())
+
+	return runErr
 }
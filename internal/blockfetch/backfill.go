@@ -0,0 +1,242 @@
+package blockfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+)
+
+const (
+	BACKFILL_RUNNING  = "running"
+	BACKFILL_DONE     = "done"
+	BACKFILL_FAILED   = "failed"
+	BACKFILL_CANCELED = "canceled"
+)
+
+// BackfillStatus reports the progress of re-scanning past blocks for a
+// newly subscribed address.
+type BackfillStatus struct {
+	Address    string     `json:"address"`
+	FromBlock  int        `json:"fromBlock"`
+	ToBlock    int        `json:"toBlock"`
+	LastBlock  int        `json:"lastBlock"`              // highest block scanned so far
+	Progress   float64    `json:"progress"`               // 0..1
+	Missed     []int      `json:"missedBlocks,omitempty"` // failed after max retries
+	State      string     `json:"state"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// Backfill re-scans blocks [from..to] for `addr` only, in the background.
+// A range longer than BACKFILL_MAX_BLOCKS starts that many blocks before
+// `to`, so a start block of 0 doesn't scan from genesis. It returns false
+// if a backfill of `addr` is already running.
+func (p *blockFetcher) Backfill(addr string, from, to int) bool {
+	a := strings.ToLower(addr)
+
+	if p.backfillMaxBlocks > 0 && to-from+1 > p.backfillMaxBlocks {
+		p.log.Printf("[WARN] Backfill of %s limited to the last %d blocks: starting at block %d instead of %d",
+			a, p.backfillMaxBlocks, to-p.backfillMaxBlocks+1, from)
+		from = to - p.backfillMaxBlocks + 1
+	}
+
+	p.mu.Lock()
+	if job, ok := p.backfills[a]; ok && job.State == BACKFILL_RUNNING {
+		p.mu.Unlock()
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(p.lifecycle)
+	job := &BackfillStatus{
+		Address:   a,
+		FromBlock: from,
+		ToBlock:   to,
+		LastBlock: from - 1,
+		State:     BACKFILL_RUNNING,
+		StartedAt: time.Now().UTC(),
+		cancel:    cancel,
+	}
+	p.backfills[a] = job
+	p.mu.Unlock()
+
+	p.backfillWG.Add(1)
+	go func() {
+		defer p.backfillWG.Done()
+		defer cancel()
+		p.runBackfill(ctx, job)
+	}()
+
+	return true
+}
+
+// CancelBackfill stops the running backfill of `addr`, if any.
+func (p *blockFetcher) CancelBackfill(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if job, ok := p.backfills[strings.ToLower(addr)]; ok && job.State == BACKFILL_RUNNING {
+		job.State = BACKFILL_CANCELED
+		job.cancel()
+	}
+}
+
+// GetBackfillStatus returns the latest backfill of `addr`, if any.
+func (p *blockFetcher) GetBackfillStatus(addr string) (BackfillStatus, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	job, ok := p.backfills[strings.ToLower(addr)]
+	if !ok {
+		return BackfillStatus{}, false
+	}
+
+	return *job, true
+}
+
+// runBackfill drives one backfill job through the regular chunked
+// ProcessRange machinery, storing for its address alone, then refetches the
+// blocks it missed. The job is held directly: a canceled job may still be
+// winding down when the address is subscribed and backfilled again.
+// Its errors are only reported in the job status: a failing backfill of old
+// blocks says nothing about the readiness of the live sync.
+func (p *blockFetcher) runBackfill(ctx context.Context, job *BackfillStatus) {
+	addr, from, to := job.Address, job.FromBlock, job.ToBlock
+	p.log.Printf("[INFO] Backfilling %s over blocks %d..%d", addr, from, to)
+
+	store := p.storeBackfillChunk(job)
+	err := p.processRange(ctx, from, to, store, nil, func(_, chunkEnd int) {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		job.LastBlock = chunkEnd
+		job.Progress = float64(chunkEnd-from+1) / float64(to-from+1)
+	})
+	if err == nil {
+		err = p.retryMissed(ctx, job, store)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+
+	if job.State == BACKFILL_CANCELED && errors.Is(err, context.Canceled) {
+		p.log.Printf("[INFO] Backfill of %s canceled at block %d", addr, job.LastBlock+1)
+		return
+	}
+
+	if err != nil {
+		job.State = BACKFILL_FAILED
+		job.Error = err.Error()
+		p.log.Printf("[ERROR] Backfill of %s failed at block %d: %v", addr, job.LastBlock+1, err)
+		return
+	}
+
+	job.State = BACKFILL_DONE
+	p.log.Printf("[INFO] Backfill of %s done", addr)
+}
+
+// retryMissed refetches the blocks `job` missed every GAP_REPAIR_INTERVAL,
+// like the gaps of the main chain, until they are all stored.
+func (p *blockFetcher) retryMissed(ctx context.Context, job *BackfillStatus, store chunkStore) error {
+	ticker := time.NewTicker(GAP_REPAIR_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		p.mu.RLock()
+		missed := len(job.Missed)
+		p.mu.RUnlock()
+
+		if missed == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		if err := p.refetchMissed(ctx, job, store); err != nil {
+			return err
+		}
+	}
+}
+
+// refetchMissed processes the blocks `job` missed again, one range per run
+// of adjacent blocks. Blocks failing again are listed back by `store`; on an
+// error, the runs not done yet are listed back too.
+func (p *blockFetcher) refetchMissed(ctx context.Context, job *BackfillStatus, store chunkStore) error {
+	p.mu.Lock()
+	missed := job.Missed
+	job.Missed = nil
+	p.mu.Unlock()
+
+	p.log.Printf("[INFO] Backfill of %s refetching %d missed block(s)", job.Address, len(missed))
+
+	for start := 0; start < len(missed); {
+		end := start
+		for end+1 < len(missed) && missed[end+1] == missed[end]+1 {
+			end++
+		}
+
+		if err := p.processRange(ctx, missed[start], missed[end], store, nil, func(int, int) {}); err != nil {
+			p.mu.Lock()
+			job.Missed = append(job.Missed, missed[start:]...)
+			sort.Ints(job.Missed)
+			p.mu.Unlock()
+
+			return err
+		}
+
+		start = end + 1
+	}
+
+	return nil
+}
+
+// storeBackfillChunk stores the token transfers and TXs of a chunk that
+// touch the address of `job`, leaving other addresses and the checkpoint
+// alone. Failed blocks aren't gaps of the main chain; they're listed in the
+// job status until runBackfill refetches them.
+func (p *blockFetcher) storeBackfillChunk(job *BackfillStatus) chunkStore {
+	addr := job.Address
+
+	return func(ctx context.Context, blocks []*rpcfetch.BlockResult, failed []int) error {
+		if len(failed) > 0 {
+			p.mu.Lock()
+			job.Missed = append(job.Missed, failed...)
+			sort.Ints(job.Missed)
+			p.mu.Unlock()
+
+			p.log.Printf("[WARN] Backfill of %s missed blocks %v", addr, failed)
+		}
+
+		transfers, err := p.fetchTokenTransfers(ctx, blocks, []string{addr})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range blocks {
+			// Stop storing as soon as the job is canceled
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			if err := p.storage.StoreBackfillTransactions(addr, toHeader(s), toStorageTransactions(s), transfers[s.BlockNumber]); err != nil {
+				return fmt.Errorf("store block %d error: %w", s.BlockNumber, err)
+			}
+		}
+
+		return nil
+	}
+}
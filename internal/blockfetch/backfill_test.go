@@ -0,0 +1,71 @@
+package blockfetch
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+func TestBackfillMaxBlocks(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxBlocks int
+		from      int
+		want      int
+	}{
+		{"from genesis", 100, 0, 901},
+		{"within the limit", 100, 950, 950},
+		{"no limit", 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestFetcher(storage.NewMemoryStorage())
+			p.rpcFetcher = newChainFetcher(nil)
+			p.backfills = make(map[string]*BackfillStatus)
+			p.backfillMaxBlocks = tt.maxBlocks
+
+			// Only the planned range matters: the job stops right away
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			p.lifecycle = ctx
+
+			p.Backfill(WATCHED, tt.from, 1000)
+			p.backfillWG.Wait()
+
+			if status, _ := p.GetBackfillStatus(WATCHED); status.FromBlock != tt.want {
+				t.Errorf("backfill starts at block %d, want %d", status.FromBlock, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefetchMissed(t *testing.T) {
+	sto := storage.NewMemoryStorage()
+	sto.SubscribeAddress(storage.Subscription{Address: WATCHED})
+
+	p := newTestFetcher(sto)
+	p.rpcFetcher = newChainFetcher(chain(1, 10))
+
+	// Block 12 isn't served yet and fails again
+	job := &BackfillStatus{Address: WATCHED, Missed: []int{3, 4, 7, 12}}
+	if err := p.refetchMissed(context.Background(), job, p.storeBackfillChunk(job)); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored []int
+	for _, tx := range sto.GetTransactions(WATCHED, storage.TxFilter{}) {
+		stored = append(stored, tx.BlockNumber)
+	}
+	if want := []int{3, 4, 7}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored blocks %v, want %v", stored, want)
+	}
+	if want := []int{12}; !reflect.DeepEqual(job.Missed, want) {
+		t.Errorf("missed blocks %v, want %v", job.Missed, want)
+	}
+	if p.lastError != "" {
+		t.Errorf("backfill error %q reported to readiness", p.lastError)
+	}
+}
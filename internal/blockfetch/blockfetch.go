@@ -3,7 +3,9 @@ package blockfetch
 import (
 	"context"
 	"sync"
+	"time"
 
+	"github.com/buildwithme/ethparser/internal/events"
 	"github.com/buildwithme/ethparser/internal/rpcfetch"
 	"github.com/buildwithme/ethparser/internal/storage"
 	"github.com/buildwithme/ethparser/pkg/constants"
@@ -12,37 +14,110 @@ import (
 )
 
 type BlockFetch interface {
-	// Run starts the block fetching process.
-	Run()
+	// Run fetches blocks until `ctx` is done or the end block is reached.
+	Run(ctx context.Context) error
 	// GetCurrentBlock returns the highest block we've successfully written to Storage.
 	GetCurrentBlock() int
 	// ProcessRange fetches and processes blocks from `start` to `end`.
 	ProcessRange(ctx context.Context, start, end int) error
+	// GetChainHead returns the latest observed tip and safe/finalized blocks.
+	GetChainHead() ChainHead
+	// GetReorgCount returns how many chain reorgs have been detected.
+	GetReorgCount() int
+	// Backfill re-scans blocks [from..to] for a single address in the background.
+	Backfill(addr string, from, to int) bool
+	// CancelBackfill stops the running backfill of an address, if any.
+	CancelBackfill(addr string)
+	// GetBackfillStatus returns the progress of the latest backfill of an address.
+	GetBackfillStatus(addr string) (BackfillStatus, bool)
+	// Events returns the bus every stored block is published to.
+	Events() *events.Bus
+	// GetReadiness reports whether the fetcher is caught up with a healthy upstream.
+	GetReadiness(ctx context.Context) Readiness
 }
 
 type blockFetcher struct {
-	concurrency   int
+	pool          *WorkerPool
 	chunkSize     int
 	maxRetries    int
+	batchSize     int
+	fetchReceipts bool
+	fetchTokens   bool
+	fetchTraces   bool
+	startBlock    int
+	endBlock      int
 	log           *logger.Logger
 	storage       storage.Storage
 	mu            sync.RWMutex
+	storeMu       sync.Mutex // serializes storing chunks and repaired gaps
 	lastProcessed int
+	rewind        int // fork a gap repair rolled back to, or -1; guarded by storeMu
 	rpcFetcher    rpcfetch.Fetcher
+	hashes        *hashWindow
+	reorgs        int
+	head          ChainHead
+	// Only touched by Run: when the head tags were last fetched, and the
+	// ones the endpoint doesn't support.
+	tagsRefreshedAt time.Time
+	unsupportedTags map[string]bool
+	backfills       map[string]*BackfillStatus
+	// Longest block range a backfill scans, 0 for no limit.
+	backfillMaxBlocks int
+	events            *events.Bus
+	lifecycle         context.Context // cancelled when Run's context is
+	stop              context.CancelFunc
+	backfillWG        sync.WaitGroup
+	readyMaxLag       int
+	caughtUp          bool
+	lastError         string
+	lastErrorAt       time.Time
 }
 
 // NewFetcher constructs a blockFetcher.
 func NewFetcher(log *logger.Logger, sto storage.Storage, rpcFetcher rpcfetch.Fetcher) BlockFetch {
 	concurrency := env.GetEnvInt(constants.ENV_CONCURRENCY, 1)
+	maxConcurrency := env.GetEnvInt(constants.ENV_MAX_CONCURRENCY, 16)
 	chunkSize := env.GetEnvInt(constants.ENV_CHUNK_SIZE, 50)
 	maxRetries := env.GetEnvInt(constants.ENV_MAX_RETRIES, 3)
+	batchSize := env.GetEnvInt(constants.ENV_BATCH_SIZE, 10)
+	fetchReceipts := env.GetEnvBool(constants.ENV_FETCH_RECEIPTS, false)
+	fetchTokens := env.GetEnvBool(constants.ENV_FETCH_TOKEN_TRANSFERS, true)
+	fetchTraces := env.GetEnvBool(constants.ENV_FETCH_TRACES, false)
+	startBlock := env.GetEnvInt(constants.ENV_DEFAULT_START_BLOCK, -1)
+	endBlock := env.GetEnvInt(constants.ENV_DEFAULT_END_BLOCK, -1)
+	readyMaxLag := env.GetEnvInt(constants.ENV_READY_MAX_LAG, 10)
+	backfillMaxBlocks := env.GetEnvInt(constants.ENV_BACKFILL_MAX_BLOCKS, 100000)
+
+	lifecycle, stop := context.WithCancel(context.Background())
 
 	return &blockFetcher{
-		log:         log,
-		storage:     sto,
-		rpcFetcher:  rpcFetcher,
-		concurrency: concurrency,
-		chunkSize:   chunkSize,
-		maxRetries:  maxRetries,
+		log:               log,
+		storage:           sto,
+		rpcFetcher:        rpcFetcher,
+		pool:              NewAdaptiveWorkerPool(concurrency, maxConcurrency),
+		chunkSize:         chunkSize,
+		maxRetries:        maxRetries,
+		batchSize:         batchSize,
+		fetchReceipts:     fetchReceipts,
+		fetchTokens:       fetchTokens,
+		fetchTraces:       fetchTraces,
+		startBlock:        startBlock,
+		endBlock:          endBlock,
+		lastProcessed:     sto.GetLastStoredBlock(),
+		rewind:            -1,
+		hashes:            seedHashWindow(sto),
+		head:              ChainHead{Latest: -1, Safe: -1, Finalized: -1},
+		unsupportedTags:   make(map[string]bool),
+		backfills:         make(map[string]*BackfillStatus),
+		backfillMaxBlocks: backfillMaxBlocks,
+		events:            events.NewBus(),
+		lifecycle:         lifecycle,
+		stop:              stop,
+		readyMaxLag:       readyMaxLag,
 	}
 }
+
+// Events returns the bus every stored block is published to.
+func (p *blockFetcher) Events() *events.Bus {
+	return p.events
+}
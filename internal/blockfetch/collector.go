@@ -2,67 +2,202 @@ package blockfetch
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
 )
 
 const BLOCK_SYNC_TIMEOUT = 2 * time.Second
 
-func (p *blockFetcher) Run() {
-	// Create a context we can cancel if needed (for graceful shutdown)
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// HEAD_WAIT_TIMEOUT is a safety net: even with a newHeads subscription we
+// re-check the tip if nothing was pushed for this long.
+const HEAD_WAIT_TIMEOUT = 30 * time.Second
+
+// Run catches up to the chain tip, then follows it until `ctx` is done or
+// DEFAULT_END_BLOCK is reached. On cancellation the in-flight chunk is
+// aborted before anything of it is stored, running backfills are waited
+// for, and Run returns nil; startup failures are returned.
+func (p *blockFetcher) Run(ctx context.Context) error {
+	// Backfills started from now on stop with `ctx`
+	context.AfterFunc(ctx, p.stop)
+	defer func() {
+		if ctx.Err() != nil {
+			p.backfillWG.Wait()
+		}
+	}()
 
 	// Fetch the current chain tip
 	latest, err := p.rpcFetcher.GetLatestBlock(ctx)
 	if err != nil {
-		p.log.Fatalf("Cannot fetch latest block: %v", err)
+		if ctx.Err() != nil {
+			return nil
+		}
+		p.recordError(err)
+		return fmt.Errorf("cannot fetch latest block: %w", err)
 	}
 
-	// Define how many blocks behind the tip to start
-	startFlag := latest - 10
-	if startFlag < 0 {
-		startFlag = 0
-	}
+	p.refreshHead(ctx, latest)
 
-	// Process the initial range from (tip - 10) up to the tip
-	err = p.ProcessRange(ctx, startFlag, latest)
-	if err != nil {
-		p.log.Fatalf("ProcessRange error: %v", err)
+	start := p.resumeBlock(latest)
+	target := p.capToEnd(latest)
+
+	// Process the initial range from the resume point up to the tip
+	if start <= target {
+		err = p.ProcessRange(ctx, start, target)
+		if ctx.Err() != nil {
+			p.log.Printf("[INFO] Stopped during catch-up. Last processed = %d", p.GetCurrentBlock())
+			return nil
+		}
+		if err != nil {
+			p.recordError(err)
+			return fmt.Errorf("initial catch-up error: %w", err)
+		}
+	} else {
+		p.setLastProcessed(start - 1)
 	}
 
+	p.setCaughtUp()
+
+	// Refetch the blocks that failed, during catch-up or from now on
+	p.backfillWG.Add(1)
+	go func() {
+		defer p.backfillWG.Done()
+		p.repairGaps(ctx)
+	}()
+
 	p.log.Printf("Initial catch-up done. Last processed = %d", p.GetCurrentBlock())
 
-	// Loop indefinitely, always trying to catch up to the latest block
+	// Prefer pushed heads over polling when the endpoint supports it
+	heads := p.subscribeHeads(ctx)
+
+	// Loop until shutdown, always trying to catch up to the latest block
 	for {
 		// If the context is canceled (e.g., SIGTERM), exit gracefully
 		select {
 		case <-ctx.Done():
-			return
+			p.log.Printf("[INFO] Stopped. Last processed = %d", p.GetCurrentBlock())
+			return nil
 		default:
 		}
 
+		if p.endBlock >= 0 && p.GetCurrentBlock() >= p.endBlock {
+			p.log.Printf("Reached end block %d, stopping", p.endBlock)
+			return nil
+		}
+
 		// Fetch the updated chain tip
 		currentTip, err := p.rpcFetcher.GetLatestBlock(ctx)
 		if err != nil {
-			p.log.Printf("Failed to fetch latest block: %v", err)
-			// Sleep briefly and retry
-			time.Sleep(BLOCK_SYNC_TIMEOUT)
+			if !errors.Is(err, context.Canceled) {
+				p.log.Printf("Failed to fetch latest block: %v", err)
+				p.recordError(err)
+			}
+			// Wait briefly and retry
+			select {
+			case <-ctx.Done():
+			case <-time.After(BLOCK_SYNC_TIMEOUT):
+			}
 			continue
 		}
 
-		// Get the last block we've processed
-		lastProcessed := p.GetCurrentBlock()
+		p.refreshHead(ctx, currentTip)
+
+		// Get the last block we've processed; gaps are left to repairGaps
+		lastProcessed := p.frontier()
+		currentTip = p.capToEnd(currentTip)
 
 		// If we're behind, process the range
 		if lastProcessed < currentTip {
 			err = p.ProcessRange(ctx, lastProcessed+1, currentTip)
-			if err != nil {
+			switch {
+			case ctx.Err() != nil:
+			case err != nil:
 				p.log.Printf("ProcessRange error (blocks %d..%d): %v", lastProcessed+1, currentTip, err)
-			} else {
+				p.recordError(err)
+			default:
 				p.log.Printf("Updated. Last processed = %d", p.GetCurrentBlock())
 			}
 		}
 
-		time.Sleep(BLOCK_SYNC_TIMEOUT)
+		heads = p.waitForNextHead(ctx, heads)
+	}
+}
+
+// resumeBlock picks the first block to process: the lowest gap left below
+// the storage checkpoint, right after the checkpoint if there is none,
+// DEFAULT_START_BLOCK on a first run, or 10 blocks behind the tip otherwise.
+func (p *blockFetcher) resumeBlock(latest int) int {
+	if checkpoint := p.storage.GetLastStoredBlock(); checkpoint >= 0 {
+		if gaps := p.storage.GetGaps(); len(gaps) > 0 && gaps[0] <= checkpoint {
+			p.log.Printf("Resuming from gap %d below storage checkpoint %d", gaps[0], checkpoint)
+			return gaps[0]
+		}
+
+		p.log.Printf("Resuming from storage checkpoint %d", checkpoint)
+		return checkpoint + 1
+	}
+
+	if p.startBlock >= 0 {
+		return p.startBlock
 	}
+
+	start := latest - 10
+	if start < 0 {
+		start = 0
+	}
+
+	return start
+}
+
+// capToEnd limits `block` to DEFAULT_END_BLOCK when one is configured.
+func (p *blockFetcher) capToEnd(block int) int {
+	if p.endBlock >= 0 && block > p.endBlock {
+		return p.endBlock
+	}
+
+	return block
+}
+
+// subscribeHeads returns a channel of pushed chain heads, or nil when the
+// RPC fetcher can only be polled.
+func (p *blockFetcher) subscribeHeads(ctx context.Context) <-chan int {
+	subscriber, ok := p.rpcFetcher.(rpcfetch.HeadSubscriber)
+	if !ok {
+		return nil
+	}
+
+	heads, err := subscriber.SubscribeNewHeads(ctx)
+	if err != nil {
+		p.log.Printf("[INFO] Polling for new blocks every %v: %v", BLOCK_SYNC_TIMEOUT, err)
+		return nil
+	}
+
+	return heads
+}
+
+// waitForNextHead blocks until a new head is pushed or, without a
+// subscription, until the next poll. Returns nil once the subscription
+// has ended so the caller falls back to polling.
+func (p *blockFetcher) waitForNextHead(ctx context.Context, heads <-chan int) <-chan int {
+	if heads == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(BLOCK_SYNC_TIMEOUT):
+		}
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+	case _, ok := <-heads:
+		if !ok {
+			p.log.Printf("[WARN] newHeads subscription ended; falling back to polling every %v", BLOCK_SYNC_TIMEOUT)
+			return nil
+		}
+	case <-time.After(HEAD_WAIT_TIMEOUT):
+	}
+
+	return heads
 }
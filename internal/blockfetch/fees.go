@@ -0,0 +1,60 @@
+package blockfetch
+
+import (
+	"math/big"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+)
+
+// txFees is the breakdown of what a transaction paid, as decimal wei.
+type txFees struct {
+	fee      string
+	burned   string
+	priority string
+	blob     string
+}
+
+// computeFees derives the fees of `t` from its receipt and the base fee of
+// its block. Without a receipt nothing is known and every field is empty.
+func computeFees(t *rpcfetch.BlockTransaction, baseFee string) txFees {
+	gasUsed, ok := parseWei(t.GasUsed)
+	if !ok {
+		return txFees{}
+	}
+
+	price, ok := parseWei(t.EffectiveGasPrice)
+	if !ok {
+		return txFees{}
+	}
+
+	fee := new(big.Int).Mul(gasUsed, price)
+
+	// Before London nothing is burned and the whole fee goes to the miner.
+	burned := new(big.Int)
+	if base, ok := parseWei(baseFee); ok {
+		burned.Mul(gasUsed, base)
+	}
+
+	fees := txFees{
+		fee:      fee.String(),
+		burned:   burned.String(),
+		priority: new(big.Int).Sub(fee, burned).String(),
+	}
+
+	blobGasUsed, okUsed := parseWei(t.BlobGasUsed)
+	blobGasPrice, okPrice := parseWei(t.BlobGasPrice)
+	if okUsed && okPrice {
+		fees.blob = new(big.Int).Mul(blobGasUsed, blobGasPrice).String()
+	}
+
+	return fees
+}
+
+// parseWei parses a decimal quantity.
+func parseWei(value string) (*big.Int, bool) {
+	if value == "" {
+		return nil, false
+	}
+
+	return new(big.Int).SetString(value, 10)
+}
@@ -2,29 +2,76 @@ package blockfetch
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/buildwithme/ethparser/internal/rpcfetch"
 	"github.com/buildwithme/ethparser/internal/storage"
 )
 
-// GetCurrentBlock returns the highest block we've successfully written to Storage.
+// GetCurrentBlock returns the checkpoint: the highest block below which
+// every block has been written to Storage, i.e. right before the first gap.
 func (p *blockFetcher) GetCurrentBlock() int {
+	last := p.frontier()
+	if gaps := p.storage.GetGaps(); len(gaps) > 0 && gaps[0] <= last {
+		return gaps[0] - 1
+	}
+
+	return last
+}
+
+// frontier returns the highest block processed, gaps aside. Processing
+// carries on from there while the gaps are repaired.
+func (p *blockFetcher) frontier() int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.lastProcessed
 }
 
+// setLastProcessed moves the in-memory frontier to `block`.
+func (p *blockFetcher) setLastProcessed(block int) {
+	p.mu.Lock()
+	p.lastProcessed = block
+	p.mu.Unlock()
+
+	p.updateLagMetrics()
+}
+
+// chunkStore stores the fetched blocks of one chunk, sorted ascending, and
+// handles the blocks that failed after max retries.
+type chunkStore func(ctx context.Context, blocks []*rpcfetch.BlockResult, failed []int) error
+
 // ProcessRange fetches blocks [start..end], chunking them to reduce memory overhead.
 func (p *blockFetcher) ProcessRange(ctx context.Context, start, end int) error {
+	return p.processRange(ctx, start, end, p.storeChunk, p.recordError, func(chunkStart, chunkEnd int) {
+		// A gap repair rolled storage back below this chunk meanwhile; the
+		// next chunk restarts from the fork
+		if p.rewindPending() {
+			return
+		}
+
+		// Update frontier; the checkpoint stops at the first gap
+		p.setLastProcessed(chunkEnd)
+
+		p.log.Printf("Chunk [%d..%d] done; lastProcessed=%d",
+			chunkStart, chunkEnd, p.GetCurrentBlock())
+	})
+}
+
+// processRange fetches blocks [start..end] chunk by chunk, hands each chunk
+// to `store` and reports it to `done`. The error of blocks failing after max
+// retries goes to `report`, if set.
+func (p *blockFetcher) processRange(ctx context.Context, start, end int, store chunkStore, report func(error), done func(chunkStart, chunkEnd int)) error {
 	if start > end {
 		return fmt.Errorf("start block (%d) > end block (%d)", start, end)
 	}
 
 	// Divide the range into chunks (e.g., 100 blocks per chunk).
-	for chunkStart := start; chunkStart <= end; chunkStart += p.chunkSize {
+	for chunkStart := start; chunkStart <= end; {
 		chunkEnd := chunkStart + p.chunkSize - 1
 		if chunkEnd > end {
 			chunkEnd = end
@@ -36,40 +83,93 @@ func (p *blockFetcher) ProcessRange(ctx context.Context, start, end int) error {
 			blocks = append(blocks, b)
 		}
 
-		err := p.processChunk(ctx, blocks)
+		// The chunk and the hash checks storing it make see one endpoint's
+		// view of the chain, so endpoints a block apart can't fake a reorg
+		chunkCtx := rpcfetch.Pin(ctx)
+
+		started := time.Now()
+		fetched, failed, fetchErr := p.fetchChunk(chunkCtx, blocks)
+
+		// Shutting down: drop the chunk whole rather than store part of it.
+		if err := ctx.Err(); err != nil {
+			p.log.Printf("[INFO] Chunk [%d..%d] aborted: %v", chunkStart, chunkEnd, err)
+			return err
+		}
+
+		if fetchErr != nil && report != nil {
+			report(fetchErr)
+		}
+
+		err := store(chunkCtx, fetched, failed)
+
+		// On a reorg, storage has been rolled back: re-ingest from the fork.
+		var reorg *reorgError
+		if errors.As(err, &reorg) {
+			chunkStart = reorg.forkBlock + 1
+			continue
+		}
+
 		if err != nil {
 			// We might log and continue or abort. We'll abort here to keep it simple.
 			p.log.Printf("Error processing chunk [%d..%d]: %v", chunkStart, chunkEnd, err)
 			return err
 		}
 
-		// Update checkpoint
-		p.mu.Lock()
-		p.lastProcessed = chunkEnd
-		p.mu.Unlock()
+		chunkDuration.Observe(time.Since(started).Seconds())
+		done(chunkStart, chunkEnd)
 
-		p.log.Printf("Chunk [%d..%d] done; lastProcessed=%d",
-			chunkStart, chunkEnd, p.lastProcessed)
+		chunkStart = chunkEnd + 1
 	}
 
 	return nil
 }
 
-// processChunk sets up a worker pool to fetch the blocks concurrently.
-func (p *blockFetcher) processChunk(ctx context.Context, blocks []int) error {
-	wp := NewWorkerPool(p.concurrency)
-	results := wp.Run(ctx, blocks, p.fetchBlockWithRetry)
+// fetchChunk runs the worker pool to fetch the blocks concurrently and
+// returns the ones that succeeded and the numbers of those that failed
+// after max retries, both sorted by block number, with the last error.
+func (p *blockFetcher) fetchChunk(ctx context.Context, blocks []int) ([]*rpcfetch.BlockResult, []int, error) {
+	wp := p.pool
+
+	fetchBatch := BatchWorkFunc(p.fetchBatch)
+	fetchBlock := WorkFunc(p.fetchBlockWithRetry)
+	var stages []StageFunc
+	if p.fetchReceipts {
+		stages = append(stages, p.attachReceipts)
+	}
+	if p.fetchTraces {
+		stages = append(stages, p.attachTraces)
+	}
+	for _, stage := range stages {
+		fetchBatch = withBatchStage(fetchBatch, stage)
+		fetchBlock = withStage(fetchBlock, stage)
+	}
+
+	var results <-chan *rpcfetch.BlockResult
+	if p.batchSize > 1 {
+		results = wp.RunBatches(ctx, blocks, p.batchSize, fetchBatch)
+	} else {
+		results = wp.Run(ctx, blocks, fetchBlock)
+	}
 
 	// We'll gather results in memory, sort them by block, then store them.
 	var successful []*rpcfetch.BlockResult
+	var failed []int
+	var lastErr error
 	for result := range results {
 		if result.Err != nil {
-			// Partial failure approach: log the error, skip that block.
+			// Partial failure approach: log the error, skip that block and
+			// let the caller record it.
+			if ctx.Err() != nil {
+				continue
+			}
+			lastErr = result.Err
 			p.log.Printf("[WARN] Block %d failed after max retries: %v", result.BlockNumber, result.Err)
+			failed = append(failed, result.BlockNumber)
 			continue
 		}
 		successful = append(successful, result)
 	}
+	sort.Ints(failed)
 
 	// Sort ascending by block number
 	for i := 0; i < len(successful)-1; i++ {
@@ -80,52 +180,326 @@ func (p *blockFetcher) processChunk(ctx context.Context, blocks []int) error {
 		}
 	}
 
+	return successful, failed, lastErr
+}
+
+// storeChunk stores a chunk for every subscribed address, checking each
+// block against the canonical chain as it goes. Failed blocks are recorded
+// as gaps first, so a crash can't lose them behind later stored blocks.
+// Blocks up to the storage checkpoint that aren't gaps were stored by an
+// earlier run and are only checked.
+func (p *blockFetcher) storeChunk(ctx context.Context, successful []*rpcfetch.BlockResult, failed []int) error {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+
+	// A gap repair rolled storage back below this chunk: re-ingest from the fork
+	if fork := p.rewind; fork >= 0 {
+		p.rewind = -1
+		if first := firstBlock(successful, failed); first > fork+1 {
+			return &reorgError{forkBlock: fork}
+		}
+	}
+
+	if len(failed) > 0 {
+		if err := p.storage.AddGaps(failed); err != nil {
+			return fmt.Errorf("record gaps %v error: %w", failed, err)
+		}
+		p.log.Printf("[WARN] Blocks %v recorded as gaps for repair", failed)
+	}
+
+	gaps := p.storage.GetGaps()
+	checkpoint := p.storage.GetLastStoredBlock()
+
+	var fresh []*rpcfetch.BlockResult
+	for _, s := range successful {
+		if s.BlockNumber > checkpoint || isGap(gaps, s.BlockNumber) {
+			fresh = append(fresh, s)
+		}
+	}
+
+	// Subscriptions added since the chunk was fetched count as well
+	if err := p.completeReceipts(ctx, fresh); err != nil {
+		return err
+	}
+
+	transfers, err := p.fetchTokenTransfers(ctx, fresh, p.storage.GetSubscribedAddresses())
+	if err != nil {
+		return err
+	}
+
 	// Insert in ascending order
 	for _, s := range successful {
-		var txs []storage.Transaction
-		for _, t := range s.Transactions {
-			tx := storage.Transaction{
-				Hash:        t.Hash,
-				From:        t.From,
-				To:          t.To,
-				BlockNumber: t.BlockNumber,
-				Value:       t.Value,
-			}
+		if p.isReorg(s.BlockNumber, s.ParentHash) {
+			return p.handleReorg(ctx, s.BlockNumber)
+		}
+
+		if s.BlockNumber <= checkpoint && !isGap(gaps, s.BlockNumber) {
+			p.hashes.put(s.BlockNumber, s.Hash)
+			continue
+		}
 
-			txs = append(txs, tx)
+		if err := p.storeBlock(s, transfers[s.BlockNumber]); err != nil {
+			return err
 		}
 
-		if err := p.storage.StoreBlockTransactions(s.BlockNumber, txs); err != nil {
-			return fmt.Errorf("store block %d error: %w", s.BlockNumber, err)
+		// A gap refetched after a restart is no longer missing
+		if isGap(gaps, s.BlockNumber) {
+			if err := p.storage.RemoveGap(s.BlockNumber); err != nil {
+				return fmt.Errorf("clear gap %d error: %w", s.BlockNumber, err)
+			}
 		}
+
+		p.hashes.put(s.BlockNumber, s.Hash)
 	}
 	return nil
 }
 
+// rewindPending reports whether a gap repair rolled storage back and the
+// main loop hasn't restarted from the fork yet.
+func (p *blockFetcher) rewindPending() bool {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+	return p.rewind >= 0
+}
+
+// firstBlock returns the lowest block of a chunk, or -1 if it is empty.
+func firstBlock(successful []*rpcfetch.BlockResult, failed []int) int {
+	first := -1
+	if len(successful) > 0 {
+		first = successful[0].BlockNumber
+	}
+	if len(failed) > 0 && (first < 0 || failed[0] < first) {
+		first = failed[0]
+	}
+
+	return first
+}
+
+// storeBlock stores the token transfers and TXs of one block for every
+// subscribed address and publishes it. Caller must hold p.storeMu.
+func (p *blockFetcher) storeBlock(s *rpcfetch.BlockResult, transfers []storage.TokenTransfer) error {
+	txs := toStorageTransactions(s)
+	if err := p.storage.StoreBlockTransactions(toHeader(s), txs, transfers); err != nil {
+		return fmt.Errorf("store block %d error: %w", s.BlockNumber, err)
+	}
+
+	subscribed := p.storage.GetSubscribedAddresses()
+	matched := subscribedTransactions(txs, subscribed)
+	countStored(matched, subscribed)
+	p.events.PublishBlock(toHeader(s), matched)
+
+	return nil
+}
+
+// subscribedTransactions returns the TXs sent by, received by or creating
+// one of the `subscribed` addresses.
+func subscribedTransactions(txs []storage.Transaction, subscribed []string) []storage.Transaction {
+	watched := make(map[string]bool, len(subscribed))
+	for _, a := range subscribed {
+		watched[strings.ToLower(a)] = true
+	}
+
+	var matched []storage.Transaction
+	for _, tx := range txs {
+		if watched[strings.ToLower(tx.From)] || watched[strings.ToLower(tx.To)] || watched[strings.ToLower(tx.ContractAddress)] {
+			matched = append(matched, tx)
+		}
+	}
+
+	return matched
+}
+
+// countStored counts the stored TXs once per subscribed party.
+func countStored(txs []storage.Transaction, subscribed []string) {
+	watched := make(map[string]bool, len(subscribed))
+	for _, a := range subscribed {
+		watched[strings.ToLower(a)] = true
+	}
+
+	for _, tx := range txs {
+		counted := make(map[string]bool, 3)
+		for _, a := range []string{tx.From, tx.To, tx.ContractAddress} {
+			a = strings.ToLower(a)
+			if watched[a] && !counted[a] {
+				counted[a] = true
+				transactionsStored.Inc(a)
+			}
+		}
+	}
+}
+
+// toHeader maps the header fields of a block onto storage.BlockHeader.
+func toHeader(result *rpcfetch.BlockResult) storage.BlockHeader {
+	return storage.BlockHeader{
+		Number:     result.BlockNumber,
+		Hash:       result.Hash,
+		ParentHash: result.ParentHash,
+		Timestamp:  result.Timestamp,
+		Miner:      result.Miner,
+		GasUsed:    result.GasUsed,
+		GasLimit:   result.GasLimit,
+		BaseFee:    result.BaseFee,
+	}
+}
+
+// toStorageTransactions maps the external TXs and internal calls of a block
+// onto storage.Transaction.
+func toStorageTransactions(result *rpcfetch.BlockResult) []storage.Transaction {
+	var txs []storage.Transaction
+	for _, t := range result.Transactions {
+		fees := computeFees(t, result.BaseFee)
+
+		var accessList []storage.AccessTuple
+		for _, a := range t.AccessList {
+			accessList = append(accessList, storage.AccessTuple{Address: a.Address, StorageKeys: a.StorageKeys})
+		}
+
+		var authorizations []storage.Authorization
+		for _, a := range t.AuthorizationList {
+			authorizations = append(authorizations, storage.Authorization{ChainID: a.ChainID, Address: a.Address, Nonce: a.Nonce})
+		}
+
+		txs = append(txs, storage.Transaction{
+			Kind:             storage.TX_KIND_EXTERNAL,
+			Hash:             t.Hash,
+			From:             t.From,
+			To:               t.To,
+			BlockNumber:      t.BlockNumber,
+			TransactionIndex: t.TransactionIndex,
+			Value:            t.Value,
+
+			Type:                 t.Type,
+			ChainID:              t.ChainID,
+			Nonce:                t.Nonce,
+			Gas:                  t.Gas,
+			GasPrice:             t.GasPrice,
+			MaxFeePerGas:         t.MaxFeePerGas,
+			MaxPriorityFeePerGas: t.MaxPriorityFeePerGas,
+			MaxFeePerBlobGas:     t.MaxFeePerBlobGas,
+			Input:                t.Input,
+			AccessList:           accessList,
+			BlobVersionedHashes:  t.BlobVersionedHashes,
+			AuthorizationList:    authorizations,
+
+			ExecutionStatus:   t.ExecutionStatus,
+			GasUsed:           t.GasUsed,
+			EffectiveGasPrice: t.EffectiveGasPrice,
+			ContractAddress:   t.ContractAddress,
+			BlobGasUsed:       t.BlobGasUsed,
+			BlobGasPrice:      t.BlobGasPrice,
+
+			Fee:         fees.fee,
+			BurnedFee:   fees.burned,
+			PriorityFee: fees.priority,
+			BlobFee:     fees.blob,
+		})
+	}
+
+	for _, c := range result.InternalCalls {
+		var txIndex int
+		if c.TxIndex < len(result.Transactions) {
+			txIndex = result.Transactions[c.TxIndex].TransactionIndex
+		}
+
+		txs = append(txs, storage.Transaction{
+			Kind:             storage.TX_KIND_INTERNAL,
+			Hash:             c.TxHash,
+			From:             c.From,
+			To:               c.To,
+			BlockNumber:      result.BlockNumber,
+			TransactionIndex: txIndex,
+			Value:            c.Value,
+			CallType:         c.Type,
+			TraceAddress:     c.TraceAddress,
+		})
+	}
+
+	return txs
+}
+
 // fetchBlockWithRetry wraps `fetchBlock` with exponential backoff retries.
+// Every attempt feeds the worker pool's concurrency.
 func (p *blockFetcher) fetchBlockWithRetry(ctx context.Context, blockNum int) (*rpcfetch.BlockResult, error) {
+	var result *rpcfetch.BlockResult
+	attempts := 0
+	err := p.retry(ctx, fmt.Sprintf("block %d", blockNum), func() (err error) {
+		attempts++
+		started := time.Now()
+		result, err = p.rpcFetcher.FetchBlock(ctx, blockNum)
+		p.pool.Observe(time.Since(started), err)
+		return err
+	})
+
+	if attempts > 1 {
+		blockRetries.Add(float64(attempts - 1))
+	}
+
+	return result, err
+}
+
+// retry runs `fn` up to maxRetries times with exponential backoff, or
+// longer if the endpoint asked to retry after a while.
+// `what` names the operation in log lines.
+func (p *blockFetcher) retry(ctx context.Context, what string, fn func() error) error {
 	var lastErr error
 
 	for attempt := 1; attempt <= p.maxRetries; attempt++ {
-		result, err := p.rpcFetcher.FetchBlock(ctx, blockNum)
+		err := fn()
 		if err == nil {
-			return result, nil
+			return nil
 		}
 
 		lastErr = err
 
 		// Exponential backoff
 		backoff := time.Duration(math.Pow(2, float64(attempt))) * time.Second
-		p.log.Printf("[ERROR] block %d attempt %d failed: %v. Retrying in %v",
-			blockNum, attempt, err, backoff)
+		if retryAfter, ok := rpcfetch.IsRateLimited(err); ok && retryAfter > backoff {
+			backoff = retryAfter
+		}
+		p.log.Printf("[ERROR] %s attempt %d failed: %v. Retrying in %v",
+			what, attempt, err, backoff)
 
 		select {
 		case <-ctx.Done():
-			return nil, ctx.Err()
+			return ctx.Err()
 		case <-time.After(backoff):
 			continue
 		}
 	}
 
-	return nil, fmt.Errorf("max retries reached: %w", lastErr)
+	return fmt.Errorf("max retries reached: %w", lastErr)
+}
+
+// fetchBatch fetches `blockNums` in one batch round-trip. Blocks the batch
+// couldn't deliver (or the whole batch, if it failed) fall back to
+// `fetchBlockWithRetry` one by one.
+func (p *blockFetcher) fetchBatch(ctx context.Context, blockNums []int) []*rpcfetch.BlockResult {
+	started := time.Now()
+	results, err := p.rpcFetcher.FetchBlocks(ctx, blockNums)
+	p.pool.Observe(time.Since(started), err)
+	if err != nil {
+		p.log.Printf("[ERROR] batch of blocks %d..%d failed: %v. Falling back to single requests",
+			blockNums[0], blockNums[len(blockNums)-1], err)
+
+		results = make([]*rpcfetch.BlockResult, len(blockNums))
+		for i, b := range blockNums {
+			results[i] = &rpcfetch.BlockResult{BlockNumber: b, Err: err}
+		}
+	}
+
+	for i, r := range results {
+		if r.Err == nil {
+			continue
+		}
+
+		retried, err := p.fetchBlockWithRetry(ctx, r.BlockNumber)
+		if err != nil {
+			results[i] = &rpcfetch.BlockResult{BlockNumber: r.BlockNumber, Err: err}
+			continue
+		}
+
+		results[i] = retried
+	}
+
+	return results
 }
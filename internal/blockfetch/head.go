@@ -0,0 +1,69 @@
+package blockfetch
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+)
+
+// HEAD_TAGS_INTERVAL is how often the safe and finalized blocks are
+// refetched. They move once per epoch, far slower than the tip.
+const HEAD_TAGS_INTERVAL = 30 * time.Second
+
+// ChainHead is the latest view of the chain tip and its finality checkpoints.
+// Safe and Finalized are -1 when the endpoint doesn't support those tags.
+type ChainHead struct {
+	Latest    int
+	Safe      int
+	Finalized int
+}
+
+// GetChainHead returns the most recently observed chain head.
+func (p *blockFetcher) GetChainHead() ChainHead {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.head
+}
+
+// refreshHead records `latest` as the tip and, every HEAD_TAGS_INTERVAL,
+// refetches the safe and finalized blocks. On failure the previous values
+// are kept.
+func (p *blockFetcher) refreshHead(ctx context.Context, latest int) {
+	head := p.GetChainHead()
+	head.Latest = latest
+
+	if time.Since(p.tagsRefreshedAt) >= HEAD_TAGS_INTERVAL {
+		p.tagsRefreshedAt = time.Now()
+		head.Safe = p.refreshTag(ctx, rpcfetch.BLOCK_TAG_SAFE, head.Safe)
+		head.Finalized = p.refreshTag(ctx, rpcfetch.BLOCK_TAG_FINALIZED, head.Finalized)
+	}
+
+	p.mu.Lock()
+	p.head = head
+	p.mu.Unlock()
+
+	p.updateLagMetrics()
+}
+
+// refreshTag returns the block `tag` points at, or `previous` if it can't
+// be fetched. A tag the endpoint doesn't support isn't asked for again.
+func (p *blockFetcher) refreshTag(ctx context.Context, tag string, previous int) int {
+	if p.unsupportedTags[tag] {
+		return previous
+	}
+
+	block, err := p.rpcFetcher.GetTaggedBlock(ctx, tag)
+	switch {
+	case err == nil:
+		return block
+	case errors.Is(err, rpcfetch.ErrTagUnsupported) || rpcfetch.IsMethodUnsupported(err):
+		p.log.Printf("[WARN] No %s block on this endpoint (%v); no longer fetching it", tag, err)
+		p.unsupportedTags[tag] = true
+	default:
+		p.log.Printf("[WARN] Failed to fetch %s block: %v", tag, err)
+	}
+
+	return previous
+}
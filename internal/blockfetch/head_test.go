@@ -0,0 +1,65 @@
+package blockfetch
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// tagFetcher answers GetTaggedBlock from `tags` and counts the calls.
+type tagFetcher struct {
+	rpcfetch.Fetcher
+	tags  map[string]error
+	calls map[string]int
+}
+
+func (f *tagFetcher) GetTaggedBlock(_ context.Context, tag string) (int, error) {
+	f.calls[tag]++
+	if err := f.tags[tag]; err != nil {
+		return 0, err
+	}
+	return 100, nil
+}
+
+func TestRefreshHead(t *testing.T) {
+	f := &tagFetcher{
+		tags: map[string]error{
+			rpcfetch.BLOCK_TAG_SAFE:      rpcfetch.ErrTagUnsupported,
+			rpcfetch.BLOCK_TAG_FINALIZED: nil,
+		},
+		calls: make(map[string]int),
+	}
+	p := newTestFetcher(storage.NewMemoryStorage())
+	p.rpcFetcher = f
+
+	p.refreshHead(context.Background(), 110)
+	p.refreshHead(context.Background(), 111)
+
+	head := p.GetChainHead()
+	if head.Latest != 111 || head.Safe != -1 || head.Finalized != 100 {
+		t.Errorf("head = %+v, want latest 111, no safe block and finalized 100", head)
+	}
+
+	// The tags are only refetched every HEAD_TAGS_INTERVAL
+	if got := f.calls[rpcfetch.BLOCK_TAG_FINALIZED]; got != 1 {
+		t.Errorf("fetched the finalized block %d times, want 1", got)
+	}
+
+	// An unsupported tag is never asked for again, a failing one is
+	f.tags[rpcfetch.BLOCK_TAG_FINALIZED] = errors.New("timeout")
+	p.tagsRefreshedAt = p.tagsRefreshedAt.Add(-HEAD_TAGS_INTERVAL)
+	p.refreshHead(context.Background(), 112)
+
+	if got := f.calls[rpcfetch.BLOCK_TAG_SAFE]; got != 1 {
+		t.Errorf("fetched the unsupported safe block %d times, want 1", got)
+	}
+	if got := f.calls[rpcfetch.BLOCK_TAG_FINALIZED]; got != 2 {
+		t.Errorf("fetched the finalized block %d times, want 2", got)
+	}
+	if head := p.GetChainHead(); head.Finalized != 100 {
+		t.Errorf("finalized = %d after a failure, want the previous 100", head.Finalized)
+	}
+}
@@ -0,0 +1,80 @@
+package blockfetch
+
+import (
+	"context"
+	"time"
+)
+
+// READY_RPC_TIMEOUT bounds the upstream probe of a readiness check.
+const READY_RPC_TIMEOUT = 3 * time.Second
+
+// Readiness is the sync state behind /readyz.
+type Readiness struct {
+	Ready        bool       `json:"ready"`
+	CaughtUp     bool       `json:"caughtUp"`
+	RPCHealthy   bool       `json:"rpcHealthy"`
+	CurrentBlock int        `json:"currentBlock"`
+	Tip          int        `json:"tip"`
+	Lag          int        `json:"lag"`
+	MaxLag       int        `json:"maxLag"`
+	LastError    string     `json:"lastError,omitempty"`
+	LastErrorAt  *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// GetReadiness probes the RPC upstream for the tip and reports ready once
+// the initial catch-up is done, the upstream answers and the lag is at
+// most READY_MAX_LAG blocks.
+func (p *blockFetcher) GetReadiness(ctx context.Context) Readiness {
+	ctx, cancel := context.WithTimeout(ctx, READY_RPC_TIMEOUT)
+	defer cancel()
+
+	tip, err := p.rpcFetcher.GetLatestBlock(ctx)
+	if err != nil {
+		p.recordError(err)
+	}
+
+	current := p.GetCurrentBlock()
+
+	p.mu.RLock()
+	r := Readiness{
+		CaughtUp:     p.caughtUp,
+		RPCHealthy:   err == nil,
+		CurrentBlock: current,
+		Tip:          p.head.Latest,
+		MaxLag:       p.readyMaxLag,
+		LastError:    p.lastError,
+	}
+	if !p.lastErrorAt.IsZero() {
+		at := p.lastErrorAt
+		r.LastErrorAt = &at
+	}
+	p.mu.RUnlock()
+
+	if err == nil {
+		r.Tip = tip
+	}
+
+	target := p.capToEnd(r.Tip)
+	if r.Lag = target - r.CurrentBlock; r.Lag < 0 {
+		r.Lag = 0
+	}
+
+	r.Ready = r.CaughtUp && r.RPCHealthy && r.Lag <= r.MaxLag
+
+	return r
+}
+
+// setCaughtUp marks the initial catch-up of Run as done.
+func (p *blockFetcher) setCaughtUp() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.caughtUp = true
+}
+
+// recordError keeps `err` as the last error reported by /readyz.
+func (p *blockFetcher) recordError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastError = err.Error()
+	p.lastErrorAt = time.Now().UTC()
+}
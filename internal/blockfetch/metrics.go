@@ -0,0 +1,54 @@
+package blockfetch
+
+import (
+	"github.com/buildwithme/ethparser/pkg/metrics"
+)
+
+// CHUNK_BUCKETS are chunk latency buckets in seconds; a chunk spans many
+// RPC round trips.
+var CHUNK_BUCKETS = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+var (
+	blockRetries = metrics.NewCounterVec("ethparser_block_fetch_retries_total",
+		"Block fetches retried after a transient RPC error.")
+	chunkDuration = metrics.NewHistogramVec("ethparser_chunk_duration_seconds",
+		"Time to fetch and store one chunk of blocks.", CHUNK_BUCKETS)
+	lastProcessedBlock = metrics.NewGaugeVec("ethparser_last_processed_block",
+		"Highest block stored.")
+	chainHeadBlock = metrics.NewGaugeVec("ethparser_chain_head_block",
+		"Latest block reported by the RPC endpoint.")
+	blocksBehindTip = metrics.NewGaugeVec("ethparser_blocks_behind_tip",
+		"Blocks between the chain tip and the last processed block.")
+	transactionsStored = metrics.NewCounterVec("ethparser_transactions_stored_total",
+		"Transactions stored for a subscribed address.", "address")
+	workerPoolSize = metrics.NewGaugeVec("ethparser_worker_pool_size",
+		"Current concurrency limit of the block worker pool.")
+	workerPoolBusy = metrics.NewGaugeVec("ethparser_worker_pool_busy",
+		"Workers currently fetching blocks.")
+	blockGaps = metrics.NewGaugeVec("ethparser_block_gaps",
+		"Blocks that failed to fetch and are waiting for repair.")
+)
+
+// updateLagMetrics publishes the checkpoint, the tip, the lag between them
+// and the number of gaps.
+func (p *blockFetcher) updateLagMetrics() {
+	last := p.GetCurrentBlock()
+	blockGaps.Set(float64(len(p.storage.GetGaps())))
+
+	p.mu.RLock()
+	latest := p.head.Latest
+	p.mu.RUnlock()
+
+	lastProcessedBlock.Set(float64(last))
+	if latest < 0 {
+		return
+	}
+
+	chainHeadBlock.Set(float64(latest))
+
+	behind := latest - last
+	if behind < 0 {
+		behind = 0
+	}
+	blocksBehindTip.Set(float64(behind))
+}
@@ -0,0 +1,131 @@
+package blockfetch
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// ABI_WORD_HEX is the length of one 32-byte ABI word in hex characters.
+const ABI_WORD_HEX = 64
+
+// decodeERC721Transfer decodes an ERC-721 Transfer: from, to and tokenId are
+// all indexed and no data is emitted.
+func decodeERC721Transfer(l *rpcfetch.Log) storage.TokenTransfer {
+	return storage.TokenTransfer{
+		TxHash:      l.TxHash,
+		LogIndex:    l.LogIndex,
+		BlockNumber: l.BlockNumber,
+		Standard:    storage.STANDARD_ERC721,
+		Contract:    l.Address,
+		From:        topicToAddress(l.Topics[1]),
+		To:          topicToAddress(l.Topics[2]),
+		TokenID:     hexToDecimal(l.Topics[3]),
+		Value:       "1",
+	}
+}
+
+// decodeERC1155TransferSingle decodes TransferSingle(operator, from, to, id, value):
+// operator, from and to are indexed, id and value are the two data words.
+func decodeERC1155TransferSingle(l *rpcfetch.Log) (storage.TokenTransfer, bool) {
+	words := abiWords(l.Data)
+	if len(l.Topics) != 4 || len(words) != 2 {
+		return storage.TokenTransfer{}, false
+	}
+
+	return storage.TokenTransfer{
+		TxHash:      l.TxHash,
+		LogIndex:    l.LogIndex,
+		BlockNumber: l.BlockNumber,
+		Standard:    storage.STANDARD_ERC1155,
+		Contract:    l.Address,
+		From:        topicToAddress(l.Topics[2]),
+		To:          topicToAddress(l.Topics[3]),
+		TokenID:     hexToDecimal(words[0]),
+		Value:       hexToDecimal(words[1]),
+	}, true
+}
+
+// decodeERC1155TransferBatch decodes TransferBatch(operator, from, to, ids[], values[])
+// into one transfer per id. The data holds the offsets of both dynamic
+// arrays, each of which starts with its length.
+func decodeERC1155TransferBatch(l *rpcfetch.Log) []storage.TokenTransfer {
+	words := abiWords(l.Data)
+	if len(l.Topics) != 4 || len(words) < 2 {
+		return nil
+	}
+
+	ids, ok := abiUintArray(words, words[0])
+	if !ok {
+		return nil
+	}
+
+	values, ok := abiUintArray(words, words[1])
+	if !ok || len(values) != len(ids) {
+		return nil
+	}
+
+	transfers := make([]storage.TokenTransfer, 0, len(ids))
+	for i := range ids {
+		transfers = append(transfers, storage.TokenTransfer{
+			TxHash:      l.TxHash,
+			LogIndex:    l.LogIndex,
+			BatchIndex:  i,
+			BlockNumber: l.BlockNumber,
+			Standard:    storage.STANDARD_ERC1155,
+			Contract:    l.Address,
+			From:        topicToAddress(l.Topics[2]),
+			To:          topicToAddress(l.Topics[3]),
+			TokenID:     hexToDecimal(ids[i]),
+			Value:       hexToDecimal(values[i]),
+		})
+	}
+
+	return transfers
+}
+
+// abiWords splits hex log data into 32-byte words.
+func abiWords(data string) []string {
+	data = strings.TrimPrefix(data, "0x")
+
+	var words []string
+	for len(data) >= ABI_WORD_HEX {
+		words = append(words, data[:ABI_WORD_HEX])
+		data = data[ABI_WORD_HEX:]
+	}
+
+	return words
+}
+
+// abiUintArray reads the uint256[] whose byte offset is encoded in `offsetWord`.
+// Log data is untrusted: offsets and lengths outside `words` are rejected.
+func abiUintArray(words []string, offsetWord string) ([]string, bool) {
+	offset, ok := new(big.Int).SetString(offsetWord, 16)
+	if !ok || !offset.IsInt64() || offset.Sign() < 0 || offset.Int64()%32 != 0 {
+		return nil, false
+	}
+
+	if offset.Int64()/32 >= int64(len(words)) {
+		return nil, false
+	}
+	start := int(offset.Int64() / 32)
+
+	length, ok := new(big.Int).SetString(words[start], 16)
+	if !ok || !length.IsInt64() || length.Int64() > int64(len(words)-start-1) {
+		return nil, false
+	}
+
+	return words[start+1 : start+1+int(length.Int64())], true
+}
+
+// hexToDecimal converts a hex quantity or word to its decimal string.
+func hexToDecimal(hex string) string {
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(hex, "0x"), 16)
+	if !ok {
+		return ""
+	}
+
+	return n.String()
+}
@@ -0,0 +1,115 @@
+package blockfetch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// word encodes `n` as one 32-byte ABI word.
+func word(n uint64) string {
+	return fmt.Sprintf("%064x", n)
+}
+
+func TestAbiUintArray(t *testing.T) {
+	// ids = [7, 8] at offset 0x40, values = [1, 2] at offset 0xa0
+	valid := []string{word(0x40), word(0xa0), word(2), word(7), word(8), word(2), word(1), word(2)}
+
+	tests := []struct {
+		name   string
+		words  []string
+		offset string
+		want   []string
+		ok     bool
+	}{
+		{"valid ids", valid, valid[0], []string{word(7), word(8)}, true},
+		{"valid values", valid, valid[1], []string{word(1), word(2)}, true},
+		{"empty array", []string{word(0x20), word(0)}, word(0x20), []string{}, true},
+		{"negative offset", valid, strings.Repeat("f", 62) + "e0", nil, false},
+		{"offset beyond int64", valid, "1" + strings.Repeat("0", 63), nil, false},
+		{"unaligned offset", valid, word(0x41), nil, false},
+		{"offset past data", valid, word(0x20 * 8), nil, false},
+		{"length past data", []string{word(0x20), word(5), word(1)}, word(0x20), nil, false},
+		{"huge length", []string{word(0x20), strings.Repeat("f", 64)}, word(0x20), nil, false},
+		{"not hex", valid, strings.Repeat("z", 64), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := abiUintArray(tt.words, tt.offset)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeERC1155(t *testing.T) {
+	from := "0x" + strings.Repeat("a", 40)
+	to := "0x" + strings.Repeat("b", 40)
+	topics := func(event string) []string {
+		return []string{event, addressToTopic("0x" + strings.Repeat("c", 40)), addressToTopic(from), addressToTopic(to)}
+	}
+
+	t.Run("single", func(t *testing.T) {
+		l := &rpcfetch.Log{Topics: topics(TRANSFER_SINGLE_TOPIC), Data: "0x" + word(42) + word(3)}
+
+		got := decodeTransfer(l)
+		if len(got) != 1 {
+			t.Fatalf("got %d transfers, want 1", len(got))
+		}
+		want := storage.TokenTransfer{Standard: storage.STANDARD_ERC1155, From: from, To: to, TokenID: "42", Value: "3"}
+		if got[0] != want {
+			t.Errorf("got %+v, want %+v", got[0], want)
+		}
+	})
+
+	tests := []struct {
+		name string
+		data []string
+		want []storage.TokenTransfer
+	}{
+		{
+			name: "batch",
+			data: []string{word(0x40), word(0xa0), word(2), word(7), word(8), word(2), word(1), word(2)},
+			want: []storage.TokenTransfer{
+				{Standard: storage.STANDARD_ERC1155, From: from, To: to, TokenID: "7", Value: "1"},
+				{Standard: storage.STANDARD_ERC1155, From: from, To: to, TokenID: "8", Value: "2", BatchIndex: 1},
+			},
+		},
+		{
+			name: "negative offset",
+			data: []string{strings.Repeat("f", 62) + "e0", word(0xa0), word(2), word(7), word(8), word(2), word(1), word(2)},
+		},
+		{
+			name: "mismatched lengths",
+			data: []string{word(0x40), word(0x80), word(1), word(7), word(2), word(1), word(2)},
+		},
+		{
+			name: "truncated",
+			data: []string{word(0x40)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &rpcfetch.Log{Topics: topics(TRANSFER_BATCH_TOPIC), Data: "0x" + strings.Join(tt.data, "")}
+
+			got := decodeTransfer(l)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d transfers, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("transfer %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package blockfetch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+)
+
+// attachReceipts fills the receipt fields of the transactions we may store:
+// those touching a subscribed address, plus contract creations, whose
+// created address is only known from the receipt.
+func (p *blockFetcher) attachReceipts(ctx context.Context, result *rpcfetch.BlockResult) error {
+	subscribed := subscribedSet(p.storage.GetSubscribedAddresses())
+
+	return p.attachReceiptsOf(ctx, result, func(t *rpcfetch.BlockTransaction) bool {
+		return t.To == "" || subscribed[strings.ToLower(t.From)] || subscribed[strings.ToLower(t.To)]
+	})
+}
+
+// completeReceipts fills the receipts missing from `blocks` because their
+// address was subscribed after the blocks were fetched. Called right
+// before storing, when the subscriptions that count are known.
+func (p *blockFetcher) completeReceipts(ctx context.Context, blocks []*rpcfetch.BlockResult) error {
+	if !p.fetchReceipts {
+		return nil
+	}
+
+	subscribed := subscribedSet(p.storage.GetSubscribedAddresses())
+	for _, b := range blocks {
+		err := p.attachReceiptsOf(ctx, b, func(t *rpcfetch.BlockTransaction) bool {
+			return t.ExecutionStatus == "" && (subscribed[strings.ToLower(t.From)] || subscribed[strings.ToLower(t.To)])
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attachReceiptsOf fills the receipt fields of the transactions of `result`
+// selected by `want`.
+func (p *blockFetcher) attachReceiptsOf(ctx context.Context, result *rpcfetch.BlockResult, want func(t *rpcfetch.BlockTransaction) bool) error {
+	var hashes []string
+	for _, t := range result.Transactions {
+		if want(t) {
+			hashes = append(hashes, t.Hash)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	receipts, err := p.fetchReceiptsWithRetry(ctx, result.BlockNumber, hashes)
+	if err != nil {
+		return fmt.Errorf("receipts: %w", err)
+	}
+
+	for _, t := range result.Transactions {
+		r, ok := receipts[t.Hash]
+		if !ok {
+			continue
+		}
+
+		t.ExecutionStatus = r.Status
+		t.GasUsed = r.GasUsed
+		t.EffectiveGasPrice = r.EffectiveGasPrice
+		t.ContractAddress = r.ContractAddress
+		t.BlobGasUsed = r.BlobGasUsed
+		t.BlobGasPrice = r.BlobGasPrice
+	}
+
+	return nil
+}
+
+// subscribedSet indexes `addresses` in lower case.
+func subscribedSet(addresses []string) map[string]bool {
+	set := make(map[string]bool, len(addresses))
+	for _, a := range addresses {
+		set[strings.ToLower(a)] = true
+	}
+
+	return set
+}
+
+// fetchReceiptsWithRetry wraps `FetchReceipts` with exponential backoff retries.
+func (p *blockFetcher) fetchReceiptsWithRetry(ctx context.Context, blockNum int, hashes []string) (map[string]*rpcfetch.Receipt, error) {
+	var receipts map[string]*rpcfetch.Receipt
+	err := p.retry(ctx, fmt.Sprintf("receipts of block %d", blockNum), func() (err error) {
+		receipts, err = p.rpcFetcher.FetchReceipts(ctx, blockNum, hashes)
+		return err
+	})
+
+	return receipts, err
+}
@@ -0,0 +1,181 @@
+package blockfetch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// REORG_WINDOW is how many recent canonical block hashes we remember.
+// Reorgs deeper than this can't be located precisely.
+const REORG_WINDOW = 64
+
+// reorgError signals that blocks after `forkBlock` were orphaned and have
+// been rolled back, so processing must restart at forkBlock+1.
+type reorgError struct {
+	forkBlock int
+}
+
+func (e *reorgError) Error() string {
+	return fmt.Sprintf("chain reorg, rolled back to block %d", e.forkBlock)
+}
+
+// hashWindow keeps the hashes of the most recently stored canonical blocks.
+type hashWindow struct {
+	mu     sync.Mutex
+	hashes map[int]string
+}
+
+func newHashWindow() *hashWindow {
+	return &hashWindow{hashes: make(map[int]string)}
+}
+
+// seedHashWindow returns a window holding the hashes of the recently
+// stored blocks, so a reorg across a restart is still detected.
+func seedHashWindow(sto storage.Storage) *hashWindow {
+	w := newHashWindow()
+	for _, h := range sto.GetRecentHeaders() {
+		w.put(h.Number, h.Hash)
+	}
+
+	return w
+}
+
+// get returns the canonical hash we stored for `block`, if still in the window.
+func (w *hashWindow) get(block int) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	h, ok := w.hashes[block]
+	return h, ok
+}
+
+// put records `hash` as canonical for `block` and evicts blocks that fell
+// out of the window.
+func (w *hashWindow) put(block int, hash string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.hashes[block] = hash
+	for b := range w.hashes {
+		if b <= block-REORG_WINDOW {
+			delete(w.hashes, b)
+		}
+	}
+}
+
+// truncate forgets every block after `forkBlock`.
+func (w *hashWindow) truncate(forkBlock int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for b := range w.hashes {
+		if b > forkBlock {
+			delete(w.hashes, b)
+		}
+	}
+}
+
+// oldest returns the lowest block still in the window, or -1 if empty.
+func (w *hashWindow) oldest() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	oldest := -1
+	for b := range w.hashes {
+		if oldest < 0 || b < oldest {
+			oldest = b
+		}
+	}
+
+	return oldest
+}
+
+// isReorg reports whether `parentHash` of `block` contradicts the hash we
+// stored for block-1.
+func (p *blockFetcher) isReorg(block int, parentHash string) bool {
+	prev, ok := p.hashes.get(block - 1)
+	return ok && prev != parentHash
+}
+
+// handleReorg walks back from `block` to the last block whose hash still
+// matches the canonical chain, rolls storage back past it and returns the
+// resulting reorgError.
+func (p *blockFetcher) handleReorg(ctx context.Context, block int) error {
+	p.mu.Lock()
+	p.reorgs++
+	count := p.reorgs
+	p.mu.Unlock()
+
+	forkBlock := p.findForkBlock(ctx, block-1)
+
+	p.log.Printf("[WARN] Reorg #%d detected at block %d; rolling back to block %d",
+		count, block, forkBlock)
+
+	removed := p.storedTransactionsFrom(forkBlock + 1)
+
+	if err := p.storage.RollbackBlocks(forkBlock + 1); err != nil {
+		return fmt.Errorf("rollback to block %d error: %w", forkBlock, err)
+	}
+
+	p.events.PublishRemoved(forkBlock, removed)
+
+	p.hashes.truncate(forkBlock)
+	p.setLastProcessed(forkBlock)
+
+	return &reorgError{forkBlock: forkBlock}
+}
+
+// storedTransactionsFrom returns the stored TXs of every subscribed address
+// at or above `block`, without duplicates.
+func (p *blockFetcher) storedTransactionsFrom(block int) []storage.Transaction {
+	seen := make(map[string]bool)
+
+	var txs []storage.Transaction
+	for _, a := range p.storage.GetSubscribedAddresses() {
+		for _, tx := range p.storage.GetTransactions(a, storage.TxFilter{FromBlock: block}) {
+			if id := storage.Cursor(tx); !seen[id] {
+				seen[id] = true
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	return txs
+}
+
+// findForkBlock refetches blocks from `from` downwards and returns the first
+// one whose hash matches our window.
+func (p *blockFetcher) findForkBlock(ctx context.Context, from int) int {
+	oldest := p.hashes.oldest()
+
+	for b := from; b >= oldest && oldest >= 0; b-- {
+		stored, ok := p.hashes.get(b)
+		if !ok {
+			continue
+		}
+
+		result, err := p.fetchBlockWithRetry(ctx, b)
+		if err != nil {
+			p.log.Printf("[WARN] Cannot refetch block %d while locating fork: %v", b, err)
+			continue
+		}
+
+		if result.Hash == stored {
+			return b
+		}
+	}
+
+	p.log.Printf("[WARN] Reorg deeper than %d blocks; rolling back the whole window", REORG_WINDOW)
+
+	return oldest - 1
+}
+
+// GetReorgCount returns how many chain reorgs have been detected.
+func (p *blockFetcher) GetReorgCount() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.reorgs
+}
@@ -0,0 +1,177 @@
+package blockfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// GAP_REPAIR_INTERVAL is how often blocks that failed after max retries are
+// fetched again.
+const GAP_REPAIR_INTERVAL = 30 * time.Second
+
+// repairGaps refetches the recorded gaps every GAP_REPAIR_INTERVAL until
+// `ctx` is done.
+func (p *blockFetcher) repairGaps(ctx context.Context) {
+	ticker := time.NewTicker(GAP_REPAIR_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.repairOnce(ctx); err != nil && ctx.Err() == nil {
+			p.log.Printf("[WARN] Gap repair error: %v", err)
+			p.recordError(err)
+		}
+	}
+}
+
+// repairOnce refetches up to CHUNK_SIZE of the lowest gaps and stores the
+// ones that succeed. Blocks failing again stay in the gap list.
+func (p *blockFetcher) repairOnce(ctx context.Context) error {
+	gaps := p.storage.GetGaps()
+	if len(gaps) == 0 {
+		return nil
+	}
+	if len(gaps) > p.chunkSize {
+		gaps = gaps[:p.chunkSize]
+	}
+
+	p.log.Printf("[INFO] Repairing %d gap(s) starting at block %d", len(gaps), gaps[0])
+
+	// Like a chunk, the gaps and their hash checks stay on one endpoint
+	ctx = rpcfetch.Pin(ctx)
+
+	fetched, _, fetchErr := p.fetchChunk(ctx, gaps)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if fetchErr != nil {
+		p.recordError(fetchErr)
+	}
+
+	if err := p.completeReceipts(ctx, fetched); err != nil {
+		return err
+	}
+
+	// One log query per run of adjacent gaps, so far apart gaps don't
+	// turn into a range spanning every block between them
+	subscribed := p.storage.GetSubscribedAddresses()
+	transfers := make(map[int][]storage.TokenTransfer)
+	for _, run := range contiguousRuns(fetched) {
+		runTransfers, err := p.fetchTokenTransfers(ctx, run, subscribed)
+		if err != nil {
+			return err
+		}
+		for block, t := range runTransfers {
+			transfers[block] = t
+		}
+	}
+
+	for _, s := range fetched {
+		if err := p.repairBlock(ctx, s, transfers); err != nil {
+			return err
+		}
+	}
+
+	p.updateLagMetrics()
+
+	return nil
+}
+
+// repairBlock stores one refetched gap and removes it from the gap list.
+// Blocks rolled back in the meantime are skipped. A gap no longer chaining
+// onto its stored parent, or whose stored child doesn't chain onto it,
+// means the chain reorganized under it: storage is rolled back to the fork
+// and the main loop re-ingests from there.
+func (p *blockFetcher) repairBlock(ctx context.Context, s *rpcfetch.BlockResult, transfers map[int][]storage.TokenTransfer) error {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+
+	if !isGap(p.storage.GetGaps(), s.BlockNumber) {
+		return nil
+	}
+
+	if p.isReorg(s.BlockNumber, s.ParentHash) {
+		p.log.Printf("[WARN] Gap %d no longer matches its stored parent", s.BlockNumber)
+		return p.rewindTo(ctx, s.BlockNumber)
+	}
+
+	if child, ok := p.storedHeader(s.BlockNumber + 1); ok && child.ParentHash != s.Hash {
+		p.log.Printf("[WARN] Gap %d no longer matches its stored child", s.BlockNumber)
+		return p.rewindTo(ctx, s.BlockNumber+1)
+	}
+
+	if err := p.storeBlock(s, transfers[s.BlockNumber]); err != nil {
+		return err
+	}
+
+	if err := p.storage.RemoveGap(s.BlockNumber); err != nil {
+		return fmt.Errorf("clear gap %d error: %w", s.BlockNumber, err)
+	}
+
+	p.hashes.put(s.BlockNumber, s.Hash)
+
+	p.log.Printf("[INFO] Gap %d repaired", s.BlockNumber)
+
+	return nil
+}
+
+// rewindTo rolls storage back past the fork below `block` and makes the
+// main loop re-ingest from there. Caller must hold p.storeMu.
+func (p *blockFetcher) rewindTo(ctx context.Context, block int) error {
+	var reorg *reorgError
+	if err := p.handleReorg(ctx, block); !errors.As(err, &reorg) {
+		return err
+	}
+
+	// Chunks fetched past the fork in the meantime must not be stored
+	if p.rewind < 0 || reorg.forkBlock < p.rewind {
+		p.rewind = reorg.forkBlock
+	}
+
+	return nil
+}
+
+// storedHeader returns the header of `block` if it is among the recently
+// stored ones.
+func (p *blockFetcher) storedHeader(block int) (storage.BlockHeader, bool) {
+	for _, h := range p.storage.GetRecentHeaders() {
+		if h.Number == block {
+			return h, true
+		}
+	}
+
+	return storage.BlockHeader{}, false
+}
+
+// contiguousRuns splits `blocks`, sorted ascending, into runs of
+// consecutive block numbers.
+func contiguousRuns(blocks []*rpcfetch.BlockResult) [][]*rpcfetch.BlockResult {
+	var runs [][]*rpcfetch.BlockResult
+
+	start := 0
+	for i := 1; i <= len(blocks); i++ {
+		if i == len(blocks) || blocks[i].BlockNumber != blocks[i-1].BlockNumber+1 {
+			runs = append(runs, blocks[start:i])
+			start = i
+		}
+	}
+
+	return runs
+}
+
+// isGap reports whether `block` is in the sorted gap list `gaps`.
+func isGap(gaps []int, block int) bool {
+	i := sort.SearchInts(gaps, block)
+	return i < len(gaps) && gaps[i] == block
+}
@@ -0,0 +1,275 @@
+package blockfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/events"
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/pkg/logger"
+)
+
+const WATCHED = "0x00000000000000000000000000000000000000aa"
+
+func newTestFetcher(sto storage.Storage) *blockFetcher {
+	return &blockFetcher{
+		log:           logger.NewLogger(),
+		storage:       sto,
+		chunkSize:     50,
+		startBlock:    -1,
+		maxRetries:    1,
+		pool:          NewAdaptiveWorkerPool(1, 1),
+		lastProcessed: sto.GetLastStoredBlock(),
+		rewind:        -1,
+		hashes:        seedHashWindow(sto),
+		head:          ChainHead{Latest: -1, Safe: -1, Finalized: -1},
+		events:        events.NewBus(),
+
+		unsupportedTags: make(map[string]bool),
+	}
+}
+
+// chain returns blocks [from..to] each holding one TX to WATCHED.
+func chain(from, to int) []*rpcfetch.BlockResult {
+	var blocks []*rpcfetch.BlockResult
+	for b := from; b <= to; b++ {
+		blocks = append(blocks, &rpcfetch.BlockResult{
+			BlockNumber: b,
+			Hash:        fmt.Sprintf("0x%x", b),
+			ParentHash:  fmt.Sprintf("0x%x", b-1),
+			Transactions: []*rpcfetch.BlockTransaction{{
+				Hash:        fmt.Sprintf("0xt%d", b),
+				To:          WATCHED,
+				BlockNumber: b,
+				Value:       "1",
+			}},
+		})
+	}
+
+	return blocks
+}
+
+// fork returns a copy of `blocks` reorganized from block `from` on: those
+// get new hashes, chaining onto each other.
+func fork(blocks []*rpcfetch.BlockResult, from int) []*rpcfetch.BlockResult {
+	forked := make([]*rpcfetch.BlockResult, len(blocks))
+	for i, b := range blocks {
+		c := *b
+		if c.BlockNumber >= from {
+			c.Hash = fmt.Sprintf("0xb%x", c.BlockNumber)
+		}
+		if c.BlockNumber > from {
+			c.ParentHash = fmt.Sprintf("0xb%x", c.BlockNumber-1)
+		}
+		forked[i] = &c
+	}
+
+	return forked
+}
+
+// chainFetcher serves `blocks` as the canonical chain.
+type chainFetcher struct {
+	rpcfetch.Fetcher
+	blocks map[int]*rpcfetch.BlockResult
+}
+
+func newChainFetcher(blocks []*rpcfetch.BlockResult) *chainFetcher {
+	f := &chainFetcher{blocks: make(map[int]*rpcfetch.BlockResult)}
+	for _, b := range blocks {
+		f.blocks[b.BlockNumber] = b
+	}
+	return f
+}
+
+func (f *chainFetcher) FetchBlock(_ context.Context, block int) (*rpcfetch.BlockResult, error) {
+	if b, ok := f.blocks[block]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("block %d not found", block)
+}
+
+func TestContiguousRuns(t *testing.T) {
+	tests := []struct {
+		blocks []int
+		want   [][]int
+	}{
+		{nil, nil},
+		{[]int{7}, [][]int{{7}}},
+		{[]int{1, 2, 3}, [][]int{{1, 2, 3}}},
+		{[]int{1, 2, 5, 6, 9}, [][]int{{1, 2}, {5, 6}, {9}}},
+		{[]int{10, 1000000}, [][]int{{10}, {1000000}}},
+	}
+
+	for _, tt := range tests {
+		var blocks []*rpcfetch.BlockResult
+		for _, b := range tt.blocks {
+			blocks = append(blocks, &rpcfetch.BlockResult{BlockNumber: b})
+		}
+
+		var got [][]int
+		for _, run := range contiguousRuns(blocks) {
+			var nums []int
+			for _, b := range run {
+				nums = append(nums, b.BlockNumber)
+			}
+			got = append(got, nums)
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("contiguousRuns(%v) = %v, want %v", tt.blocks, got, tt.want)
+		}
+	}
+}
+
+func TestResumeBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored int
+		gaps   []int
+		want   int
+	}{
+		{"after the checkpoint", 20, nil, 21},
+		{"from the lowest gap", 20, []int{12, 15}, 12},
+		{"gaps past the checkpoint", 20, []int{25}, 21},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sto := storage.NewMemoryStorage()
+			sto.StoreBlockTransactions(storage.BlockHeader{Number: tt.stored}, nil, nil)
+			sto.AddGaps(tt.gaps)
+
+			if got := newTestFetcher(sto).resumeBlock(100); got != tt.want {
+				t.Errorf("resumeBlock() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreChunkSkipsStoredBlocks(t *testing.T) {
+	sto := storage.NewMemoryStorage()
+	sto.SubscribeAddress(storage.Subscription{Address: WATCHED})
+	p := newTestFetcher(sto)
+
+	// A previous run stored blocks 1..5 but block 3 failed
+	blocks := chain(1, 5)
+	if err := p.storeChunk(context.Background(), append(blocks[:2:2], blocks[3:]...), []int{3}); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart, processing resumes from the gap and goes past it
+	p = newTestFetcher(sto)
+	if err := p.storeChunk(context.Background(), chain(p.resumeBlock(100), 6), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	for _, tx := range sto.GetTransactions(WATCHED, storage.TxFilter{}) {
+		got = append(got, tx.BlockNumber)
+	}
+	if want := []int{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored blocks %v, want %v once each", got, want)
+	}
+	if gaps := sto.GetGaps(); len(gaps) != 0 {
+		t.Errorf("gaps %v left after the refetch", gaps)
+	}
+}
+
+func TestStoreChunkAfterRepairRollback(t *testing.T) {
+	sto := storage.NewMemoryStorage()
+	p := newTestFetcher(sto)
+
+	// A gap repair rolled storage back to block 7 while blocks 21.. were fetched
+	p.rewind = 7
+
+	err := p.storeChunk(context.Background(), chain(21, 22), nil)
+
+	var reorg *reorgError
+	if !errors.As(err, &reorg) || reorg.forkBlock != 7 {
+		t.Fatalf("got %v, want a restart from block 7", err)
+	}
+	if p.rewind != -1 {
+		t.Error("the rollback is still pending after the restart")
+	}
+
+	// The chunk starting right after the fork goes through
+	if err := p.storeChunk(context.Background(), chain(8, 9), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReorgDetectedAcrossRestart(t *testing.T) {
+	sto := storage.NewMemoryStorage()
+	if err := newTestFetcher(sto).storeChunk(context.Background(), chain(1, 10), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// While stopped, blocks from 9 on were replaced
+	canonical := fork(chain(1, 11), 9)
+
+	p := newTestFetcher(sto)
+	p.rpcFetcher = newChainFetcher(canonical)
+
+	err := p.storeChunk(context.Background(), canonical[10:], nil)
+
+	var reorg *reorgError
+	if !errors.As(err, &reorg) || reorg.forkBlock != 8 {
+		t.Fatalf("got %v, want a rollback to block 8", err)
+	}
+	if got := sto.GetLastStoredBlock(); got != 8 {
+		t.Errorf("last stored block %d, want 8", got)
+	}
+}
+
+func TestRepairBlockChecksTheChain(t *testing.T) {
+	ctx := context.Background()
+
+	// Blocks 1..10 stored, but block 5 failed
+	setup := func(t *testing.T) (*blockFetcher, storage.Storage) {
+		sto := storage.NewMemoryStorage()
+		p := newTestFetcher(sto)
+
+		blocks := chain(1, 10)
+		if err := p.storeChunk(ctx, append(blocks[:4:4], blocks[5:]...), []int{5}); err != nil {
+			t.Fatal(err)
+		}
+
+		return p, sto
+	}
+
+	t.Run("repaired", func(t *testing.T) {
+		p, sto := setup(t)
+
+		if err := p.repairBlock(ctx, chain(5, 5)[0], nil); err != nil {
+			t.Fatal(err)
+		}
+		if gaps := sto.GetGaps(); len(gaps) != 0 {
+			t.Errorf("gaps %v left", gaps)
+		}
+		if _, ok := p.hashes.get(5); !ok {
+			t.Error("the repaired block is missing from the hash window")
+		}
+	})
+
+	t.Run("reorganized under the gap", func(t *testing.T) {
+		p, sto := setup(t)
+
+		// Block 5 was replaced, so the stored block 6 is orphaned
+		canonical := fork(chain(1, 10), 5)
+		p.rpcFetcher = newChainFetcher(canonical)
+
+		if err := p.repairBlock(ctx, canonical[4], nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := sto.GetLastStoredBlock(); got != 4 {
+			t.Errorf("last stored block %d, want 4", got)
+		}
+		if p.rewind != 4 {
+			t.Errorf("main loop restarts after block %d, want 4", p.rewind)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package blockfetch
+
+import (
+	"context"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+)
+
+// StageFunc enriches a fetched block with data from further RPC calls,
+// such as receipts or traces.
+type StageFunc func(ctx context.Context, result *rpcfetch.BlockResult) error
+
+// withStage wraps a WorkFunc so each fetched block also goes through `stage`.
+func withStage(fn WorkFunc, stage StageFunc) WorkFunc {
+	return func(ctx context.Context, blockNum int) (*rpcfetch.BlockResult, error) {
+		result, err := fn(ctx, blockNum)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := stage(ctx, result); err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	}
+}
+
+// withBatchStage wraps a BatchWorkFunc so each fetched block also goes through `stage`.
+func withBatchStage(fn BatchWorkFunc, stage StageFunc) BatchWorkFunc {
+	return func(ctx context.Context, blockNums []int) []*rpcfetch.BlockResult {
+		results := fn(ctx, blockNums)
+		for i, r := range results {
+			if r.Err != nil {
+				continue
+			}
+
+			if err := stage(ctx, r); err != nil {
+				results[i] = &rpcfetch.BlockResult{BlockNumber: r.BlockNumber, Err: err}
+			}
+		}
+
+		return results
+	}
+}
@@ -0,0 +1,176 @@
+package blockfetch
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+const (
+	// TRANSFER_TOPIC is keccak256("Transfer(address,address,uint256)"),
+	// shared by ERC-20 and ERC-721.
+	TRANSFER_TOPIC = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+	// TRANSFER_SINGLE_TOPIC is keccak256("TransferSingle(address,address,address,uint256,uint256)").
+	TRANSFER_SINGLE_TOPIC = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+	// TRANSFER_BATCH_TOPIC is keccak256("TransferBatch(address,address,address,uint256[],uint256[])").
+	TRANSFER_BATCH_TOPIC = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+)
+
+// fetchTokenTransfers collects the ERC-20, ERC-721 and ERC-1155 transfer
+// events of `blocks` sent or received by one of `addresses`, grouped by
+// block number.
+func (p *blockFetcher) fetchTokenTransfers(ctx context.Context, blocks []*rpcfetch.BlockResult, addresses []string) (map[int][]storage.TokenTransfer, error) {
+	if !p.fetchTokens || len(blocks) == 0 {
+		return nil, nil
+	}
+
+	var addressTopics []string
+	for _, a := range addresses {
+		addressTopics = append(addressTopics, addressToTopic(a))
+	}
+
+	if len(addressTopics) == 0 {
+		return nil, nil
+	}
+
+	filter := rpcfetch.LogFilter{
+		FromBlock: blocks[0].BlockNumber,
+		ToBlock:   blocks[len(blocks)-1].BlockNumber,
+	}
+
+	logs, err := p.fetchTransferLogs(ctx, filter, addressTopics)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[int]string, len(blocks))
+	for _, b := range blocks {
+		hashes[b.BlockNumber] = b.Hash
+	}
+
+	byBlock := make(map[int][]*rpcfetch.Log)
+	for _, l := range logs {
+		byBlock[l.BlockNumber] = append(byBlock[l.BlockNumber], l)
+	}
+
+	transfers := make(map[int][]storage.TokenTransfer)
+	for blockNum, blockLogs := range byBlock {
+		hash, ok := hashes[blockNum]
+		if !ok {
+			continue
+		}
+
+		// The range query may have raced a reorg: re-read the logs of the
+		// exact block we are about to store.
+		if blockLogs[0].BlockHash != hash {
+			blockLogs, err = p.fetchTransferLogs(ctx, rpcfetch.LogFilter{BlockHash: hash}, addressTopics)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, l := range blockLogs {
+			transfers[blockNum] = append(transfers[blockNum], decodeTransfer(l)...)
+		}
+	}
+
+	return transfers, nil
+}
+
+// fetchTransferLogs fetches transfer logs whose indexed 'from' or 'to' is one
+// of `addressTopics`. Topics can't express OR across positions, so this takes
+// one query per side, and ERC-1155 (which indexes the operator first) needs
+// its own pair.
+func (p *blockFetcher) fetchTransferLogs(ctx context.Context, filter rpcfetch.LogFilter, addressTopics []string) ([]*rpcfetch.Log, error) {
+	var logs []*rpcfetch.Log
+
+	erc1155 := []string{TRANSFER_SINGLE_TOPIC, TRANSFER_BATCH_TOPIC}
+	for _, topics := range [][][]string{
+		{{TRANSFER_TOPIC}, addressTopics},
+		{{TRANSFER_TOPIC}, nil, addressTopics},
+		{erc1155, nil, addressTopics},
+		{erc1155, nil, nil, addressTopics},
+	} {
+		filter.Topics = topics
+
+		var side []*rpcfetch.Log
+		err := p.retry(ctx, fmt.Sprintf("logs of blocks %d..%d", filter.FromBlock, filter.ToBlock), func() (err error) {
+			side, err = p.rpcFetcher.FetchLogs(ctx, filter)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		logs = append(logs, side...)
+	}
+
+	return logs, nil
+}
+
+// decodeTransfer decodes a transfer log of any supported standard. An
+// ERC-1155 batch yields one transfer per token id.
+func decodeTransfer(l *rpcfetch.Log) []storage.TokenTransfer {
+	if len(l.Topics) == 0 {
+		return nil
+	}
+
+	switch {
+	case l.Topics[0] == TRANSFER_TOPIC && len(l.Topics) == 3:
+		if t, ok := decodeERC20Transfer(l); ok {
+			return []storage.TokenTransfer{t}
+		}
+	case l.Topics[0] == TRANSFER_TOPIC && len(l.Topics) == 4:
+		return []storage.TokenTransfer{decodeERC721Transfer(l)}
+	case l.Topics[0] == TRANSFER_SINGLE_TOPIC:
+		if t, ok := decodeERC1155TransferSingle(l); ok {
+			return []storage.TokenTransfer{t}
+		}
+	case l.Topics[0] == TRANSFER_BATCH_TOPIC:
+		return decodeERC1155TransferBatch(l)
+	}
+
+	return nil
+}
+
+// decodeERC20Transfer decodes an ERC-20 Transfer: from and to are indexed,
+// the amount is the only data word.
+func decodeERC20Transfer(l *rpcfetch.Log) (storage.TokenTransfer, bool) {
+	if len(l.Topics) != 3 || l.Topics[0] != TRANSFER_TOPIC {
+		return storage.TokenTransfer{}, false
+	}
+
+	value, ok := new(big.Int).SetString(strings.TrimPrefix(l.Data, "0x"), 16)
+	if !ok {
+		return storage.TokenTransfer{}, false
+	}
+
+	return storage.TokenTransfer{
+		TxHash:      l.TxHash,
+		LogIndex:    l.LogIndex,
+		BlockNumber: l.BlockNumber,
+		Standard:    storage.STANDARD_ERC20,
+		Contract:    l.Address,
+		From:        topicToAddress(l.Topics[1]),
+		To:          topicToAddress(l.Topics[2]),
+		Value:       value.String(),
+	}, true
+}
+
+// addressToTopic left-pads an address to a 32-byte topic.
+func addressToTopic(addr string) string {
+	return "0x" + strings.Repeat("0", 24) + strings.TrimPrefix(strings.ToLower(addr), "0x")
+}
+
+// topicToAddress extracts the address from a 32-byte topic.
+func topicToAddress(topic string) string {
+	if len(topic) < 40 {
+		return ""
+	}
+
+	return "0x" + topic[len(topic)-40:]
+}
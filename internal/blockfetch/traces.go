@@ -0,0 +1,31 @@
+package blockfetch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+)
+
+// attachTraces fills the internal calls of a block. Matching them against
+// subscriptions is left to storage, as for regular transactions.
+func (p *blockFetcher) attachTraces(ctx context.Context, result *rpcfetch.BlockResult) error {
+	var calls []*rpcfetch.InternalCall
+	err := p.retry(ctx, fmt.Sprintf("traces of block %d", result.BlockNumber), func() (err error) {
+		calls, err = p.rpcFetcher.FetchTraces(ctx, result.BlockNumber)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("traces: %w", err)
+	}
+
+	for _, c := range calls {
+		if c.TxHash == "" && c.TxIndex < len(result.Transactions) {
+			c.TxHash = result.Transactions[c.TxIndex].Hash
+		}
+	}
+
+	result.InternalCalls = calls
+
+	return nil
+}
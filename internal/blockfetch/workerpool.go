@@ -2,28 +2,190 @@ package blockfetch
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/buildwithme/ethparser/internal/rpcfetch"
 )
 
+const (
+	// DECREASE_COOLDOWN keeps a burst of concurrent failures from cutting
+	// the concurrency more than once.
+	DECREASE_COOLDOWN = 2 * time.Second
+
+	// LATENCY_TOLERANCE is how many times the baseline latency a request
+	// may take before the pool backs off.
+	LATENCY_TOLERANCE = 3.0
+
+	// ERROR_SMOOTHING is the weight of the newest outcome in the error rate.
+	ERROR_SMOOTHING = 0.05
+
+	// MAX_ERROR_RATE is the smoothed share of failed requests above which
+	// the pool backs off. Rate limits back it off right away.
+	MAX_ERROR_RATE = 0.1
+
+	// BASELINE_DRIFT lets the baseline latency follow a node that got
+	// lastingly slower, so it isn't mistaken for overload forever.
+	BASELINE_DRIFT = 0.01
+)
+
 // WorkFunc is the signature for the job function each worker runs.
 type WorkFunc func(ctx context.Context, blockNum int) (*rpcfetch.BlockResult, error)
 
-// WorkerPool manages concurrency for processing blocks.
+// BatchWorkFunc is the signature for a job that handles several blocks at once.
+// It must return one result per block.
+type BatchWorkFunc func(ctx context.Context, blockNums []int) []*rpcfetch.BlockResult
+
+// WorkerPool manages concurrency for processing blocks. Its limit adapts
+// AIMD-style between 1 and `max`: it grows by one worker per `limit`
+// requests that succeed in time, and halves on a rate limit, a high error
+// rate or a latency spike.
 type WorkerPool struct {
-	concurrency int
+	mu           sync.Mutex
+	cond         *sync.Cond
+	limit        int
+	max          int
+	busy         int
+	successes    int
+	latency      float64 // EWMA of request latency, in seconds
+	errorRate    float64 // EWMA of failed requests, 0..1
+	baseline     float64 // lowest smoothed latency seen, slowly drifting
+	lastDecrease time.Time
 }
 
-// NewWorkerPool with a given concurrency level.
+// NewWorkerPool with a given concurrency level, which errors may lower
+// for a while but never raise.
 func NewWorkerPool(concurrency int) *WorkerPool {
+	return NewAdaptiveWorkerPool(concurrency, concurrency)
+}
+
+// NewAdaptiveWorkerPool starts at `concurrency` workers and adapts between
+// 1 and `max` as requests are observed.
+func NewAdaptiveWorkerPool(concurrency, max int) *WorkerPool {
 	if concurrency <= 0 {
 		concurrency = 1
 	}
-	return &WorkerPool{concurrency: concurrency}
+	if max < concurrency {
+		max = concurrency
+	}
+
+	wp := &WorkerPool{limit: concurrency, max: max}
+	wp.cond = sync.NewCond(&wp.mu)
+	workerPoolSize.Set(float64(concurrency))
+
+	return wp
+}
+
+// Limit returns the current concurrency limit.
+func (wp *WorkerPool) Limit() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.limit
+}
+
+// acquire blocks until a worker slot is free under the current limit.
+func (wp *WorkerPool) acquire() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	for wp.busy >= wp.limit {
+		wp.cond.Wait()
+	}
+	wp.busy++
+	workerPoolBusy.Set(float64(wp.busy))
+}
+
+// release frees a worker slot.
+func (wp *WorkerPool) release() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	wp.busy--
+	workerPoolBusy.Set(float64(wp.busy))
+	wp.cond.Broadcast()
+}
+
+// Observe adapts the limit to the outcome of one RPC request. Cancelled
+// requests are ignored.
+func (wp *WorkerPool) Observe(latency time.Duration, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.max == 1 {
+		return
+	}
+
+	failed := 0.0
+	if err != nil {
+		failed = 1
+	}
+	wp.errorRate = ERROR_SMOOTHING*failed + (1-ERROR_SMOOTHING)*wp.errorRate
+
+	if err != nil {
+		if _, limited := rpcfetch.IsRateLimited(err); limited || wp.errorRate > MAX_ERROR_RATE {
+			wp.decrease()
+		}
+		return
+	}
+
+	seconds := latency.Seconds()
+	if wp.latency == 0 {
+		wp.latency, wp.baseline = seconds, seconds
+	} else {
+		wp.latency = rpcfetch.LATENCY_SMOOTHING*seconds + (1-rpcfetch.LATENCY_SMOOTHING)*wp.latency
+	}
+
+	if wp.latency < wp.baseline {
+		wp.baseline = wp.latency
+	} else {
+		wp.baseline += (wp.latency - wp.baseline) * BASELINE_DRIFT
+	}
+
+	if wp.latency > wp.baseline*LATENCY_TOLERANCE {
+		wp.decrease()
+		return
+	}
+
+	wp.successes++
+	if wp.successes >= wp.limit && wp.limit < wp.max {
+		wp.successes = 0
+		wp.setLimit(wp.limit + 1)
+	}
+}
+
+// decrease halves the limit, at most once per DECREASE_COOLDOWN.
+// Caller must hold wp.mu.
+func (wp *WorkerPool) decrease() {
+	wp.successes = 0
+	if time.Since(wp.lastDecrease) < DECREASE_COOLDOWN {
+		return
+	}
+	wp.lastDecrease = time.Now()
+
+	wp.setLimit(wp.limit / 2)
+}
+
+// setLimit moves the limit to `limit`, kept within 1..max, and wakes the
+// waiting workers. Caller must hold wp.mu.
+func (wp *WorkerPool) setLimit(limit int) {
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > wp.max {
+		limit = wp.max
+	}
+
+	wp.limit = limit
+	workerPoolSize.Set(float64(limit))
+	wp.cond.Broadcast()
 }
 
-// Run spins up `concurrency` workers that consume from `blocks` and call `fn`.
+// Run spins up a worker per block, at most `limit` at a time, that call `fn`.
 // Returns a channel of results to be read by the caller.
 func (wp *WorkerPool) Run(ctx context.Context, blocks []int, fn WorkFunc) <-chan *rpcfetch.BlockResult {
 	results := make(chan *rpcfetch.BlockResult, len(blocks))
@@ -32,15 +194,14 @@ func (wp *WorkerPool) Run(ctx context.Context, blocks []int, fn WorkFunc) <-chan
 		defer close(results)
 
 		var wg sync.WaitGroup
-		sem := make(chan struct{}, wp.concurrency)
 
 		for _, b := range blocks {
 			wg.Add(1)
-			sem <- struct{}{} // blocks until a slot is available
+			wp.acquire() // blocks until a slot is available
 
 			go func(blockNum int) {
 				defer wg.Done()
-				defer func() { <-sem }() // release slot
+				defer wp.release()
 
 				r, err := fn(ctx, blockNum)
 				if err != nil {
@@ -57,3 +218,43 @@ func (wp *WorkerPool) Run(ctx context.Context, blocks []int, fn WorkFunc) <-chan
 
 	return results
 }
+
+// RunBatches splits `blocks` into batches of `batchSize` and spins up a
+// worker per batch, at most `limit` at a time, that call `fn`.
+// Returns a channel of per-block results to be read by the caller.
+func (wp *WorkerPool) RunBatches(ctx context.Context, blocks []int, batchSize int, fn BatchWorkFunc) <-chan *rpcfetch.BlockResult {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+
+	results := make(chan *rpcfetch.BlockResult, len(blocks))
+
+	go func() {
+		defer close(results)
+
+		var wg sync.WaitGroup
+
+		for start := 0; start < len(blocks); start += batchSize {
+			end := start + batchSize
+			if end > len(blocks) {
+				end = len(blocks)
+			}
+
+			wg.Add(1)
+			wp.acquire() // blocks until a slot is available
+
+			go func(batch []int) {
+				defer wg.Done()
+				defer wp.release()
+
+				for _, r := range fn(ctx, batch) {
+					results <- r
+				}
+			}(blocks[start:end])
+		}
+
+		wg.Wait()
+	}()
+
+	return results
+}
@@ -0,0 +1,151 @@
+package events
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+const (
+	EVENT_BLOCK       = "block"
+	EVENT_TRANSACTION = "transaction"
+	EVENT_REMOVED     = "removed"
+
+	// SUBSCRIBER_BUFFER is how many events a subscriber may lag behind
+	// before it is dropped.
+	SUBSCRIBER_BUFFER = 256
+)
+
+type (
+	// Event is a new block, a matched transaction or a transaction removed
+	// by a reorg. ID is a storage cursor (see storage.Cursor), so a reader
+	// can resume after it from storage.
+	Event struct {
+		ID          string
+		Type        string
+		Block       storage.BlockHeader
+		Transaction storage.Transaction // EVENT_TRANSACTION and EVENT_REMOVED only
+	}
+
+	// Subscriber receives the events published after it subscribed. C is
+	// closed when it unsubscribes or falls SUBSCRIBER_BUFFER events behind.
+	Subscriber struct {
+		C         <-chan Event
+		ch        chan Event
+		addresses map[string]bool
+	}
+
+	// Bus fans published events out to its subscribers.
+	Bus struct {
+		mu          sync.Mutex
+		subscribers map[*Subscriber]struct{}
+	}
+)
+
+// NewBus returns an empty Bus.
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscriber]struct{})}
+}
+
+// Subscribe registers a subscriber for the transactions of `addresses`
+// (all matched transactions if empty) and every block event.
+func (b *Bus) Subscribe(addresses []string) *Subscriber {
+	ch := make(chan Event, SUBSCRIBER_BUFFER)
+	s := &Subscriber{C: ch, ch: ch, addresses: make(map[string]bool)}
+	for _, a := range addresses {
+		s.addresses[strings.ToLower(a)] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers[s] = struct{}{}
+
+	return s
+}
+
+// Unsubscribe removes `s` and closes its channel. It is safe to call more
+// than once.
+func (b *Bus) Unsubscribe(s *Subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(s)
+}
+
+// remove drops `s`. Caller must hold b.mu.
+func (b *Bus) remove(s *Subscriber) {
+	if _, ok := b.subscribers[s]; !ok {
+		return
+	}
+
+	delete(b.subscribers, s)
+	close(s.ch)
+}
+
+// PublishBlock publishes the block event of `header`, then one event per
+// matched TX of the block.
+func (b *Bus) PublishBlock(header storage.BlockHeader, txs []storage.Transaction) {
+	events := make([]Event, 0, len(txs)+1)
+	for _, tx := range txs {
+		tx.Block = &header
+		events = append(events, Event{ID: storage.Cursor(tx), Type: EVENT_TRANSACTION, Block: header, Transaction: tx})
+	}
+	events = append(events, Event{ID: storage.BlockCursor(header.Number), Type: EVENT_BLOCK, Block: header})
+
+	b.publish(events)
+}
+
+// PublishRemoved publishes one event per TX rolled back by a reorg that
+// forked after `forkBlock`. Their ID resumes right after the fork, so the
+// re-ingested blocks are replayed.
+func (b *Bus) PublishRemoved(forkBlock int, txs []storage.Transaction) {
+	events := make([]Event, 0, len(txs))
+	for _, tx := range txs {
+		ev := Event{ID: storage.BlockCursor(forkBlock), Type: EVENT_REMOVED, Transaction: tx}
+		if tx.Block != nil {
+			ev.Block = *tx.Block
+		}
+		events = append(events, ev)
+	}
+
+	b.publish(events)
+}
+
+// publish hands `events` to every subscriber that wants them.
+func (b *Bus) publish(events []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for s := range b.subscribers {
+		for _, ev := range events {
+			if !s.wants(ev) {
+				continue
+			}
+
+			select {
+			case s.ch <- ev:
+			default:
+				// Too slow: drop it, the reader resumes from storage.
+				b.remove(s)
+			}
+
+			if _, ok := b.subscribers[s]; !ok {
+				break
+			}
+		}
+	}
+}
+
+// wants reports whether `ev` passes the address filter of `s`.
+func (s *Subscriber) wants(ev Event) bool {
+	if ev.Type == EVENT_BLOCK || len(s.addresses) == 0 {
+		return true
+	}
+
+	tx := ev.Transaction
+	return s.addresses[strings.ToLower(tx.From)] ||
+		s.addresses[strings.ToLower(tx.To)] ||
+		s.addresses[strings.ToLower(tx.ContractAddress)]
+}
@@ -2,9 +2,18 @@ package httphandlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/buildwithme/ethparser/internal/parser"
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/internal/webhook"
+	"github.com/buildwithme/ethparser/pkg/constants"
+	"github.com/buildwithme/ethparser/pkg/env"
+	"github.com/buildwithme/ethparser/pkg/metrics"
 )
 
 // or wherever your Parser interface is
@@ -12,23 +21,76 @@ import (
 
 // Handlers wraps a Parser instance to serve HTTP requests.
 type Handlers struct {
-	Parser parser.Parser
+	Parser   parser.Parser
+	Fetcher  rpcfetch.Fetcher
+	Notifier *webhook.Notifier
+
+	watches        *wsWatches
+	allowedOrigins []string
 }
 
-// New returns a struct with all route handlers bound to a Parser.
-func New(p parser.Parser) *Handlers {
-	return &Handlers{Parser: p}
+// New returns a struct with all route handlers bound to a Parser, the
+// RPC fetcher feeding it and the webhook notifier. WebSocket upgrades from
+// browsers are accepted from this host and the WS_ALLOWED_ORIGINS list.
+func New(p parser.Parser, f rpcfetch.Fetcher, n *webhook.Notifier) *Handlers {
+	var origins []string
+	for _, o := range strings.Split(env.GetEnvString(constants.ENV_WS_ALLOWED_ORIGINS, ""), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+
+	return &Handlers{Parser: p, Fetcher: f, Notifier: n, watches: newWSWatches(), allowedOrigins: origins}
 }
 
 func (s *Handlers) RegisterHandlers() {
 	// GET /current-block
-	http.HandleFunc("/current-block", s.HandleCurrentBlock)
+	handle("/current-block", s.HandleCurrentBlock)
+
+	// POST|DELETE /subscribe?address=0x123...
+	handle("/subscribe", s.HandleSubscribe)
 
-	// POST /subscribe?address=0x123...
-	http.HandleFunc("/subscribe", s.HandleSubscribe)
+	// GET /gaps
+	handle("/gaps", s.HandleGaps)
+
+	// GET /subscriptions
+	handle("/subscriptions", s.HandleSubscriptions)
+
+	// GET /subscriptions/0x123.../backfill
+	handle("/subscriptions/{address}/backfill", s.HandleBackfill)
 
 	// GET /transactions?address=0x123...
-	http.HandleFunc("/transactions", s.HandleTransactions)
+	handle("/transactions", s.HandleTransactions)
+
+	// GET /token-transfers?address=0x123...
+	handle("/token-transfers", s.HandleTokenTransfers)
+
+	// GET /nft-transfers?address=0x123...
+	handle("/nft-transfers", s.HandleNFTTransfers)
+
+	// GET /fees?address=0x123...
+	handle("/fees", s.HandleFees)
+
+	// GET /rpc-endpoints
+	handle("/rpc-endpoints", s.HandleRPCEndpoints)
+
+	// GET /stream?address=0x123...
+	handle("/stream", s.HandleStream)
+
+	// WebSocket /ws
+	handle("/ws", s.HandleWebSocket)
+
+	// GET /healthz
+	handle("/healthz", s.HandleHealthz)
+
+	// GET /readyz
+	handle("/readyz", s.HandleReadyz)
+
+	// GET /metrics
+	http.Handle("/metrics", metrics.Default.Handler())
+
+	// GET /webhooks/dead-letters
+	handle("/webhooks/dead-letters", s.HandleDeadLetters)
 }
 
 // HandleCurrentBlock responds with the last parsed block.
@@ -37,37 +99,212 @@ func (h *Handlers) HandleCurrentBlock(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		currentBlock := h.Parser.GetCurrentBlock()
-		resp := map[string]int{"currentBlock": currentBlock}
+		head := h.Parser.GetChainHead()
+		resp := map[string]int{
+			"currentBlock":   currentBlock,
+			"latestBlock":    head.Latest,
+			"safeBlock":      head.Safe,
+			"finalizedBlock": head.Finalized,
+		}
 		writeJSON(w, resp)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// HandleSubscribe adds an address to the observer list.
-//   - Expects POST with `address` query param
-//   - Returns {"subscribed":true|false}
-//   - Responds 400 if `address` is missing, or 405 for non-POST
+// HandleGaps lists the blocks that failed after max retries and wait for
+// the background repair.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Returns {"currentBlock":N,"gaps":[...]}; currentBlock, the checkpoint,
+//     stays right before the lowest gap until it's repaired
+func (h *Handlers) HandleGaps(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		resp := map[string]any{
+			"currentBlock": h.Parser.GetCurrentBlock(),
+			"gaps":         h.Parser.GetGaps(),
+		}
+		writeJSON(w, resp)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSubscribe adds an address to, or removes it from, the observer list.
+//   - Expects POST or DELETE with `address` query param
+//   - POST takes optional `label`, `start_block` and `webhook` params, and returns
+//     {"subscribed":true|false}; a `start_block` already processed starts a backfill
+//     of the address history (at most BACKFILL_MAX_BLOCKS blocks back), and `webhook` (http or https URL, outside private
+//     networks unless WEBHOOK_ALLOW_PRIVATE) receives the matched transactions of
+//     every new block
+//   - DELETE returns {"unsubscribed":true|false}; the address history is kept
+//     unless RETAIN_UNSUBSCRIBED is false
+//   - Responds 400 if `address` is missing or `start_block` or `webhook` is malformed
+//     or forbidden, or 405 otherwise
 func (h *Handlers) HandleSubscribe(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+
 	switch r.Method {
 	case http.MethodPost:
-		address := r.URL.Query().Get("address")
 		if address == "" {
 			http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
 			return
 		}
-		subscribed := h.Parser.Subscribe(address)
+
+		sub := storage.Subscription{
+			Address: address,
+			Label:   r.URL.Query().Get("label"),
+		}
+
+		backfill := false
+		if value := r.URL.Query().Get("start_block"); value != "" {
+			startBlock, err := strconv.Atoi(value)
+			if err != nil || startBlock < 0 {
+				http.Error(w, "Invalid 'start_block' query parameter", http.StatusBadRequest)
+				return
+			}
+			sub.StartBlock = startBlock
+			backfill = true
+		}
+
+		if value := r.URL.Query().Get("webhook"); value != "" {
+			if err := h.Notifier.CheckURL(value); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid 'webhook' query parameter: %v", err), http.StatusBadRequest)
+				return
+			}
+			sub.WebhookURL = value
+		}
+
+		// An address WebSocket clients watch is already subscribed, and stays
+		// theirs; once subscribed here, their sessions ending won't drop it
+		subscribed := h.Parser.AddSubscription(sub, backfill)
+		if subscribed {
+			h.watches.disown(address)
+		}
 		writeJSON(w, map[string]bool{"subscribed": subscribed})
+	case http.MethodDelete:
+		if address == "" {
+			http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
+			return
+		}
+
+		unsubscribed := h.Parser.Unsubscribe(address)
+		writeJSON(w, map[string]bool{"unsubscribed": unsubscribed})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleSubscriptions lists the observed addresses.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Returns []storage.Subscription in JSON, oldest first, with label,
+//     creation time and start block
+func (h *Handlers) HandleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, h.Parser.ListSubscriptions())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleBackfill reports the history backfill of an address.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Returns blockfetch.BackfillStatus in JSON: block range, last block
+//     scanned, progress (0..1), state (running, done or failed) and the
+//     blocks missed after max retries
+//   - Responds 404 if the address was never backfilled
+func (h *Handlers) HandleBackfill(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		status, ok := h.Parser.GetBackfillStatus(r.PathValue("address"))
+		if !ok {
+			http.Error(w, "No backfill for this address", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, status)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// HandleTransactions returns inbound/outbound transactions for a given address.
+// HandleTransactions returns one page of inbound/outbound transactions for a given address.
+//   - Expects GET with `address` query param. Optional params narrow the page:
+//     `from_block`/`to_block`, `from_time`/`to_time` (unix seconds, inclusive),
+//     `direction` (in|out), `min_value` (wei), `order` (asc|desc),
+//     `limit` (default 100, max 1000) and `cursor`; `limit=0` and `to_block=0`
+//     are rejected, `from_block=0` is the same as no lower bound
+//   - Returns parser.TransactionPage in JSON: `transactions`, each with its Block
+//     header, Confirmations and Status (pending-confirmations, confirmed or
+//     finalized), and `next`, the cursor of the following page if any
+//   - Responds 400 if `address` is missing or a param is malformed, or 405 for non-GET
+func (h *Handlers) HandleTransactions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
+			return
+		}
+
+		query, err := parseTxQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := h.Parser.QueryTransactions(address, query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, page)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleTokenTransfers returns ERC-20 transfers sent or received by an address.
 //   - Expects GET with `address` query param
-//   - Returns []storage.Transaction in JSON
+//   - Returns []storage.TokenTransfer in JSON
 //   - Responds 400 if `address` is missing, or 405 for non-GET
-func (h *Handlers) HandleTransactions(w http.ResponseWriter, r *http.Request) {
+func (h *Handlers) HandleTokenTransfers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, h.Parser.GetTokenTransfers(address))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleNFTTransfers returns ERC-721 and ERC-1155 transfers sent or received by an address.
+//   - Expects GET with `address` query param
+//   - Returns []storage.TokenTransfer in JSON, with contract, token id and amount
+//   - Responds 400 if `address` is missing, or 405 for non-GET
+func (h *Handlers) HandleNFTTransfers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, h.Parser.GetNFTTransfers(address))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleFees returns the value and fees paid by the outgoing transactions of an address.
+//   - Expects GET with `address` query param
+//   - Returns parser.FeeSummary in JSON; fees need FETCH_RECEIPTS
+//   - Responds 400 if `address` is missing, or 405 for non-GET
+func (h *Handlers) HandleFees(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		address := r.URL.Query().Get("address")
@@ -75,8 +312,63 @@ func (h *Handlers) HandleTransactions(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Missing 'address' query parameter", http.StatusBadRequest)
 			return
 		}
-		txs := h.Parser.GetTransactions(address)
-		writeJSON(w, txs)
+		writeJSON(w, h.Parser.GetFeeSummary(address))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleRPCEndpoints reports per-endpoint health, latency and error counts.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+func (h *Handlers) HandleRPCEndpoints(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, h.Fetcher.Stats())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleDeadLetters lists the webhook deliveries that ran out of attempts.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Returns []webhook.Delivery in JSON, oldest first, with URL, payload,
+//     attempts and last error
+func (h *Handlers) HandleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, h.Notifier.DeadLetters())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleHealthz reports that the process is up and serving.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Returns {"status":"ok"}
+func (h *Handlers) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, map[string]string{"status": "ok"})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleReadyz reports whether the server is in sync with the chain.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Returns blockfetch.Readiness in JSON: current block, tip, lag, max lag,
+//     catch-up and upstream state, and the last error
+//   - Responds 503 unless the initial catch-up is done, the RPC upstream
+//     answers and the lag is at most READY_MAX_LAG blocks
+func (h *Handlers) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		readiness := h.Parser.GetReadiness(r.Context())
+		if !readiness.Ready {
+			writeJSONStatus(w, http.StatusServiceUnavailable, readiness)
+			return
+		}
+		writeJSON(w, readiness)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
@@ -84,6 +376,12 @@ func (h *Handlers) HandleTransactions(w http.ResponseWriter, r *http.Request) {
 
 // writeJSON is a small helper to consistently write JSON responses.
 func writeJSON(w http.ResponseWriter, data interface{}) {
+	writeJSONStatus(w, http.StatusOK, data)
+}
+
+// writeJSONStatus writes a JSON response with `status`.
+func writeJSONStatus(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	_ = json.NewEncoder(w).Encode(data)
 }
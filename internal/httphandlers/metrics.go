@@ -0,0 +1,60 @@
+package httphandlers
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/buildwithme/ethparser/pkg/metrics"
+)
+
+var httpDuration = metrics.NewHistogramVec("ethparser_http_request_duration_seconds",
+	"HTTP request latency, by handler and status code.", nil, "handler", "code")
+
+// handle registers `handler` on `pattern` with latency metrics.
+func handle(pattern string, handler http.HandlerFunc) {
+	http.HandleFunc(pattern, instrument(pattern, handler))
+}
+
+// instrument records the latency of `handler` under the route `pattern`.
+func instrument(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		handler(rec, r)
+
+		httpDuration.Observe(time.Since(started).Seconds(), pattern, strconv.Itoa(rec.status))
+	}
+}
+
+// statusRecorder captures the status code while still letting streaming
+// (Flusher) and WebSocket (Hijacker) handlers reach the connection.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
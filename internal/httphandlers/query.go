@@ -0,0 +1,92 @@
+package httphandlers
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+const (
+	DEFAULT_PAGE_SIZE = 100
+	MAX_PAGE_SIZE     = 1000
+)
+
+// parseTxQuery reads the filter, order and pagination query params of
+// /transactions.
+func parseTxQuery(r *http.Request) (storage.TxQuery, error) {
+	params := r.URL.Query()
+	query := storage.TxQuery{
+		Order:  storage.ORDER_ASC,
+		Cursor: params.Get("cursor"),
+		Limit:  DEFAULT_PAGE_SIZE,
+	}
+
+	ints := map[string]*int{
+		"from_block": &query.FromBlock,
+		"to_block":   &query.ToBlock,
+		"limit":      &query.Limit,
+	}
+	for param, dst := range ints {
+		if value := params.Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return query, fmt.Errorf("invalid '%s' query parameter", param)
+			}
+			*dst = n
+		}
+	}
+
+	times := map[string]*int64{
+		"from_time": &query.FromTime,
+		"to_time":   &query.ToTime,
+	}
+	for param, dst := range times {
+		if value := params.Get(param); value != "" {
+			t, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return query, fmt.Errorf("invalid '%s' query parameter", param)
+			}
+			*dst = t
+		}
+	}
+
+	// 0 would mean "no limit" to storage, and a 0 upper bound would be
+	// ignored; a 0 lower bound is no bound, as blocks start at 0.
+	for _, param := range []string{"limit", "to_block"} {
+		if params.Get(param) != "" && *ints[param] == 0 {
+			return query, fmt.Errorf("invalid '%s' query parameter: must be at least 1", param)
+		}
+	}
+
+	if query.Limit > MAX_PAGE_SIZE {
+		query.Limit = MAX_PAGE_SIZE
+	}
+
+	switch direction := params.Get("direction"); direction {
+	case "", storage.DIRECTION_IN, storage.DIRECTION_OUT:
+		query.Direction = direction
+	default:
+		return query, fmt.Errorf("invalid 'direction' query parameter: use in or out")
+	}
+
+	switch order := params.Get("order"); order {
+	case "":
+	case storage.ORDER_ASC, storage.ORDER_DESC:
+		query.Order = order
+	default:
+		return query, fmt.Errorf("invalid 'order' query parameter: use asc or desc")
+	}
+
+	if value := params.Get("min_value"); value != "" {
+		min, ok := new(big.Int).SetString(value, 10)
+		if !ok || min.Sign() < 0 {
+			return query, fmt.Errorf("invalid 'min_value' query parameter")
+		}
+		query.MinValue = min
+	}
+
+	return query, nil
+}
@@ -0,0 +1,40 @@
+package httphandlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTxQuery(t *testing.T) {
+	tests := []struct {
+		params    string
+		wantErr   bool
+		wantLimit int
+		wantFrom  int
+	}{
+		{"", false, DEFAULT_PAGE_SIZE, 0},
+		{"limit=1", false, 1, 0},
+		{"limit=5000", false, MAX_PAGE_SIZE, 0},
+		{"limit=0", true, 0, 0},
+		{"limit=-1", true, 0, 0},
+		{"from_block=0", false, DEFAULT_PAGE_SIZE, 0},
+		{"from_block=7&to_block=9", false, DEFAULT_PAGE_SIZE, 7},
+		{"to_block=0", true, 0, 0},
+		{"order=sideways", true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.params, func(t *testing.T) {
+			query, err := parseTxQuery(httptest.NewRequest("GET", "/transactions?"+tt.params, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if query.Limit != tt.wantLimit || query.FromBlock != tt.wantFrom {
+				t.Errorf("limit %d, from_block %d, want %d, %d", query.Limit, query.FromBlock, tt.wantLimit, tt.wantFrom)
+			}
+		})
+	}
+}
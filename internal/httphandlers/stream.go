@@ -0,0 +1,104 @@
+package httphandlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/buildwithme/ethparser/internal/parser"
+)
+
+// HEARTBEAT_INTERVAL keeps idle streams open through proxies.
+const HEARTBEAT_INTERVAL = 15 * time.Second
+
+// HandleStream streams new blocks and matched transactions as Server-Sent Events.
+//   - Only supports GET, otherwise 405 Method Not Allowed
+//   - Optional `address` param (comma-separated or repeated) narrows the
+//     transactions to those addresses; block events are always sent
+//   - Each event has an `id`, an `event` type (block, transaction or removed,
+//     for a transaction rolled back by a reorg) and the storage.BlockHeader or
+//     parser.Transaction as JSON `data`
+//   - A `Last-Event-ID` header (or `last_event_id` param) first replays the
+//     stored transactions after that event
+//   - Responds 400 if `Last-Event-ID` is malformed, or 410 if more than
+//     parser.MAX_REPLAY_EVENTS transactions follow it: the client must resync
+//     from /transactions
+func (h *Handlers) HandleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	var addresses []string
+	for _, value := range r.URL.Query()["address"] {
+		for _, a := range strings.Split(value, ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				addresses = append(addresses, a)
+			}
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	stream, err := h.Parser.Stream(r.Context(), addresses, lastEventID)
+	if errors.Is(err, parser.ErrReplayTooLarge) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid 'Last-Event-ID'", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case ev, ok := <-stream:
+			if !ok {
+				return
+			}
+
+			var data interface{} = ev.Block
+			if ev.Transaction != nil {
+				data = ev.Transaction
+			}
+
+			payload, err := json.Marshal(data)
+			if err != nil {
+				return
+			}
+
+			if _, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.ID, ev.Type, payload); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,380 @@
+package httphandlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buildwithme/ethparser/internal/events"
+	"github.com/buildwithme/ethparser/internal/parser"
+	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/pkg/websocket"
+)
+
+const (
+	WS_SUBSCRIBE_TRANSACTIONS = "transactions"
+	WS_SUBSCRIBE_NEW_HEADS    = "newHeads"
+
+	WS_EVENT_TRANSACTION  = "transaction"
+	WS_EVENT_CONFIRMATION = "confirmation"
+	WS_EVENT_REMOVED      = "removed"
+
+	WS_PING_INTERVAL = 30 * time.Second
+
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+)
+
+type (
+	wsRequest struct {
+		JSONRPC string            `json:"jsonrpc"`
+		ID      json.RawMessage   `json:"id"`
+		Method  string            `json:"method"`
+		Params  []json.RawMessage `json:"params"`
+	}
+
+	wsResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result,omitempty"`
+		Error   *wsError        `json:"error,omitempty"`
+	}
+
+	wsError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	wsNotification struct {
+		JSONRPC string               `json:"jsonrpc"`
+		Method  string               `json:"method"`
+		Params  wsNotificationParams `json:"params"`
+	}
+
+	// wsNotificationParams carries one subscription result.
+	wsNotificationParams struct {
+		Subscription string      `json:"subscription"`
+		Result       interface{} `json:"result"`
+	}
+
+	// wsTransactionsOptions is the optional second param of
+	// eth_subscribe("transactions").
+	wsTransactionsOptions struct {
+		Addresses   []string `json:"addresses"`
+		LastEventID string   `json:"lastEventId"`
+	}
+
+	// wsTransactionEvent is the result of a "transactions" notification.
+	wsTransactionEvent struct {
+		Event       string              `json:"event"`
+		ID          string              `json:"id"`
+		Transaction *parser.Transaction `json:"transaction"`
+	}
+
+	// wsSession is one WebSocket connection and its subscriptions.
+	wsSession struct {
+		parser  parser.Parser
+		watches *wsWatches
+		conn    *websocket.Conn
+		ctx     context.Context
+		close   context.CancelFunc
+
+		mu     sync.Mutex
+		subs   map[string]func() // stops a subscription and releases its addresses
+		nextID int
+	}
+)
+
+// HandleWebSocket serves a JSON-RPC 2.0 push API mirroring eth_subscribe.
+//   - Expects a WebSocket upgrade, otherwise 400 Bad Request
+//   - Responds 403 to browsers from an origin other than this host or
+//     WS_ALLOWED_ORIGINS
+//   - eth_subscribe ["transactions", {"addresses": [...], "lastEventId": "..."}]
+//     watches the addresses (every subscribed one if omitted; at most
+//     WS_MAX_ADDRESSES 0x-prefixed 20-byte hex addresses) and notifies
+//     `transaction`, `confirmation` (status upgrade) and `removed` (reorg) events;
+//     `lastEventId` first replays the stored transactions after that event.
+//     Addresses the parser didn't watch are unwatched again once no
+//     subscription needs them
+//   - eth_subscribe ["newHeads"] notifies every new block header
+//   - eth_unsubscribe [id] cancels a subscription
+func (h *Handlers) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !allowOrigin(r, h.allowedOrigins) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// Hijacked connections aren't drained by http.Server.Shutdown: the
+	// session ends with the request context instead.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	s := &wsSession{
+		parser:  h.Parser,
+		watches: h.watches,
+		conn:    conn,
+		ctx:     ctx,
+		close:   cancel,
+		subs:    make(map[string]func()),
+	}
+	defer s.unsubscribeAll()
+
+	go s.keepAlive()
+
+	for {
+		message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var req wsRequest
+		if err := json.Unmarshal(message, &req); err != nil || req.Method == "" {
+			s.reply(req.ID, nil, &wsError{Code: rpcInvalidRequest, Message: "invalid request"})
+			continue
+		}
+
+		result, rpcErr := s.handle(req)
+		s.reply(req.ID, result, rpcErr)
+	}
+}
+
+// handle dispatches one request.
+func (s *wsSession) handle(req wsRequest) (interface{}, *wsError) {
+	switch req.Method {
+	case "eth_subscribe":
+		return s.subscribe(req.Params)
+	case "eth_unsubscribe":
+		var id string
+		if len(req.Params) != 1 || json.Unmarshal(req.Params[0], &id) != nil {
+			return nil, &wsError{Code: rpcInvalidParams, Message: "expected [subscription id]"}
+		}
+		return s.unsubscribe(id), nil
+	default:
+		return nil, &wsError{Code: rpcMethodNotFound, Message: fmt.Sprintf("method %s not found", req.Method)}
+	}
+}
+
+// subscribe starts a "transactions" or "newHeads" subscription and returns its id.
+func (s *wsSession) subscribe(params []json.RawMessage) (interface{}, *wsError) {
+	var kind string
+	if len(params) == 0 || json.Unmarshal(params[0], &kind) != nil {
+		return nil, &wsError{Code: rpcInvalidParams, Message: "expected [subscription type, options]"}
+	}
+
+	var opts wsTransactionsOptions
+	switch kind {
+	case WS_SUBSCRIBE_TRANSACTIONS:
+		if len(params) > 1 {
+			if err := json.Unmarshal(params[1], &opts); err != nil {
+				return nil, &wsError{Code: rpcInvalidParams, Message: "invalid options"}
+			}
+		}
+		opts.Addresses = normalizeAddresses(opts.Addresses)
+
+		if len(opts.Addresses) > WS_MAX_ADDRESSES {
+			return nil, &wsError{Code: rpcInvalidParams, Message: fmt.Sprintf("at most %d addresses", WS_MAX_ADDRESSES)}
+		}
+		for _, a := range opts.Addresses {
+			if !isAddress(a) {
+				return nil, &wsError{Code: rpcInvalidParams, Message: fmt.Sprintf("invalid address %q", a)}
+			}
+		}
+	case WS_SUBSCRIBE_NEW_HEADS:
+	default:
+		return nil, &wsError{Code: rpcInvalidParams, Message: fmt.Sprintf("unsupported subscription %q", kind)}
+	}
+
+	// Clients subscribe to addresses the parser may not watch yet; the
+	// session holds them until the subscription stops.
+	held := s.watches.acquire(s.parser, opts.Addresses)
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			s.watches.release(s.parser, held)
+		})
+	}
+
+	stream, err := s.parser.Stream(ctx, opts.Addresses, opts.LastEventID)
+	if errors.Is(err, parser.ErrReplayTooLarge) {
+		stop()
+		return nil, &wsError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if err != nil {
+		stop()
+		return nil, &wsError{Code: rpcInvalidParams, Message: "invalid lastEventId"}
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("0x%x", s.nextID)
+	s.subs[id] = stop
+	s.mu.Unlock()
+
+	if kind == WS_SUBSCRIBE_NEW_HEADS {
+		go s.forwardHeads(ctx, id, stream)
+	} else {
+		go s.forwardTransactions(ctx, id, stream)
+	}
+
+	return id, nil
+}
+
+// unsubscribe cancels subscription `id`, reporting whether it existed.
+func (s *wsSession) unsubscribe(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stop, ok := s.subs[id]
+	if ok {
+		stop()
+		delete(s.subs, id)
+	}
+
+	return ok
+}
+
+// unsubscribeAll stops every subscription of the session once it ends.
+func (s *wsSession) unsubscribeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, stop := range s.subs {
+		stop()
+		delete(s.subs, id)
+	}
+}
+
+// forwardHeads notifies the header of every new block.
+func (s *wsSession) forwardHeads(ctx context.Context, id string, stream <-chan parser.StreamEvent) {
+	for ev := range stream {
+		if ev.Type == events.EVENT_BLOCK {
+			s.notify(id, ev.Block)
+		}
+	}
+
+	s.streamClosed(ctx)
+}
+
+// forwardTransactions notifies matched and removed transactions. The ones
+// not finalized yet are re-checked on every new block, and notified again
+// when their status changes.
+func (s *wsSession) forwardTransactions(ctx context.Context, id string, stream <-chan parser.StreamEvent) {
+	pending := make(map[string]parser.Transaction)
+
+	for ev := range stream {
+		switch ev.Type {
+		case events.EVENT_TRANSACTION:
+			key := storage.Cursor(ev.Transaction.Transaction)
+			if ev.Transaction.Status != parser.STATUS_FINALIZED {
+				pending[key] = *ev.Transaction
+			}
+			s.notify(id, wsTransactionEvent{Event: WS_EVENT_TRANSACTION, ID: ev.ID, Transaction: ev.Transaction})
+		case events.EVENT_REMOVED:
+			delete(pending, storage.Cursor(ev.Transaction.Transaction))
+			s.notify(id, wsTransactionEvent{Event: WS_EVENT_REMOVED, ID: ev.ID, Transaction: ev.Transaction})
+		case events.EVENT_BLOCK:
+			for key, tx := range pending {
+				upgraded := s.parser.Annotate(tx.Transaction)
+				if upgraded.Status == tx.Status {
+					continue
+				}
+
+				if upgraded.Status == parser.STATUS_FINALIZED {
+					delete(pending, key)
+				} else {
+					pending[key] = upgraded
+				}
+				s.notify(id, wsTransactionEvent{Event: WS_EVENT_CONFIRMATION, ID: key, Transaction: &upgraded})
+			}
+		}
+	}
+
+	s.streamClosed(ctx)
+}
+
+// streamClosed drops the connection if the stream ended while its
+// subscription was still live, i.e. the client fell too far behind; it
+// should reconnect with its last event id.
+func (s *wsSession) streamClosed(ctx context.Context) {
+	if ctx.Err() == nil {
+		s.close()
+		s.conn.Close()
+	}
+}
+
+// keepAlive pings the client until the session ends, then closes the
+// connection so the read loop returns.
+func (s *wsSession) keepAlive() {
+	ticker := time.NewTicker(WS_PING_INTERVAL)
+	defer ticker.Stop()
+	defer s.conn.Close()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.conn.Ping(); err != nil {
+				s.close()
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// reply answers request `id`.
+func (s *wsSession) reply(id json.RawMessage, result interface{}, rpcErr *wsError) {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+
+	s.write(wsResponse{JSONRPC: "2.0", ID: id, Result: result, Error: rpcErr})
+}
+
+// notify sends an eth_subscription notification.
+func (s *wsSession) notify(id string, result interface{}) {
+	s.write(wsNotification{
+		JSONRPC: "2.0",
+		Method:  "eth_subscription",
+		Params:  wsNotificationParams{Subscription: id, Result: result},
+	})
+}
+
+// write sends one JSON message; a failed write ends the session.
+func (s *wsSession) write(message interface{}) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	if err := s.conn.WriteMessage(data); err != nil {
+		s.close()
+	}
+}
+
+// normalizeAddresses lowercases and trims `addresses`.
+func normalizeAddresses(addresses []string) []string {
+	var out []string
+	for _, a := range addresses {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			out = append(out, a)
+		}
+	}
+
+	return out
+}
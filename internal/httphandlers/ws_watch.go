@@ -0,0 +1,107 @@
+package httphandlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/buildwithme/ethparser/internal/parser"
+)
+
+// WS_MAX_ADDRESSES bounds the addresses of one "transactions" subscription.
+const WS_MAX_ADDRESSES = 100
+
+// wsWatches reference-counts the addresses WebSocket clients made the
+// parser watch. An address is unsubscribed once the last client session
+// watching it ends, unless it was subscribed through /subscribe meanwhile.
+type wsWatches struct {
+	mu   sync.Mutex
+	refs map[string]int
+}
+
+func newWSWatches() *wsWatches {
+	return &wsWatches{refs: make(map[string]int)}
+}
+
+// acquire makes `p` watch `addresses` and returns those it holds a
+// reference on: the ones not watched through /subscribe.
+func (w *wsWatches) acquire(p parser.Parser, addresses []string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var held []string
+	for _, a := range addresses {
+		if w.refs[a] == 0 && !p.Subscribe(a) {
+			// Already subscribed through /subscribe: not ours to drop
+			continue
+		}
+
+		w.refs[a]++
+		held = append(held, a)
+	}
+
+	return held
+}
+
+// release drops the references of `addresses` and unsubscribes the ones
+// no session watches anymore.
+func (w *wsWatches) release(p parser.Parser, addresses []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, a := range addresses {
+		if w.refs[a] == 0 {
+			continue
+		}
+
+		w.refs[a]--
+		if w.refs[a] == 0 {
+			delete(w.refs, a)
+			p.Unsubscribe(a)
+		}
+	}
+}
+
+// disown hands `address` over to /subscribe: releasing it no longer
+// unsubscribes it.
+func (w *wsWatches) disown(address string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.refs, strings.ToLower(address))
+}
+
+// isAddress reports whether `a` is a 0x-prefixed 20-byte hex address.
+func isAddress(a string) bool {
+	if len(a) != 42 || !strings.HasPrefix(a, "0x") {
+		return false
+	}
+
+	for _, c := range a[2:] {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allowOrigin reports whether a WebSocket upgrade may proceed. Requests
+// without an Origin header don't come from a browser; browser requests
+// must come from this host or one of `allowed` ("*" allows any).
+func allowOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(a, origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
@@ -0,0 +1,134 @@
+package httphandlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/parser"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// watchParser records the subscriptions of a parser.
+type watchParser struct {
+	parser.Parser
+	subscribed map[string]bool
+}
+
+func (p *watchParser) Subscribe(a string) bool {
+	if p.subscribed[a] {
+		return false
+	}
+	p.subscribed[a] = true
+	return true
+}
+
+func (p *watchParser) AddSubscription(sub storage.Subscription, _ bool) bool {
+	return p.Subscribe(sub.Address)
+}
+
+func (p *watchParser) Unsubscribe(a string) bool {
+	ok := p.subscribed[a]
+	delete(p.subscribed, a)
+	return ok
+}
+
+func TestWSWatches(t *testing.T) {
+	const (
+		ours   = "0x00000000000000000000000000000000000000a1"
+		theirs = "0x00000000000000000000000000000000000000b0"
+	)
+
+	p := &watchParser{subscribed: map[string]bool{theirs: true}}
+	w := newWSWatches()
+
+	first := w.acquire(p, []string{ours, theirs})
+	second := w.acquire(p, []string{ours})
+	if len(first) != 1 || first[0] != ours {
+		t.Fatalf("held %v, want only %s", first, ours)
+	}
+
+	w.release(p, first)
+	if !p.subscribed[ours] {
+		t.Fatal("released while another session still watches it")
+	}
+
+	w.release(p, second)
+	if p.subscribed[ours] {
+		t.Error("still watched after the last session released it")
+	}
+	if !p.subscribed[theirs] {
+		t.Error("an address subscribed through /subscribe was unwatched")
+	}
+
+	// Subscribed through /subscribe while a session holds it
+	held := w.acquire(p, []string{ours})
+	w.disown(ours)
+	w.release(p, held)
+	if !p.subscribed[ours] {
+		t.Error("a disowned address was unwatched")
+	}
+}
+
+func TestSubscribeKeepsWSWatches(t *testing.T) {
+	const address = "0x00000000000000000000000000000000000000a1"
+
+	p := &watchParser{subscribed: make(map[string]bool)}
+	h := &Handlers{Parser: p, watches: newWSWatches()}
+
+	held := h.watches.acquire(p, []string{address})
+
+	// Already watched by a session: the POST fails and the session keeps it
+	rec := httptest.NewRecorder()
+	h.HandleSubscribe(rec, httptest.NewRequest(http.MethodPost, "/subscribe?address="+address, nil))
+	if got := rec.Body.String(); got != "{\"subscribed\":false}\n" {
+		t.Fatalf("got %s, want subscribed false", got)
+	}
+
+	h.watches.release(p, held)
+	if p.subscribed[address] {
+		t.Error("the address outlived the session watching it")
+	}
+}
+
+func TestIsAddress(t *testing.T) {
+	tests := map[string]bool{
+		"0x00000000000000000000000000000000000000a1": true,
+		"0xABCDEF0000000000000000000000000000000000": true,
+		"0x1234": false,
+		"00000000000000000000000000000000000000a1aa":  false,
+		"0x00000000000000000000000000000000000000g1":  false,
+		"0x00000000000000000000000000000000000000a1a": false,
+	}
+
+	for a, want := range tests {
+		if got := isAddress(a); got != want {
+			t.Errorf("isAddress(%q) = %v, want %v", a, got, want)
+		}
+	}
+}
+
+func TestAllowOrigin(t *testing.T) {
+	tests := []struct {
+		origin  string
+		allowed []string
+		want    bool
+	}{
+		{"", nil, true},
+		{"http://example.com", nil, true},
+		{"https://evil.test", nil, false},
+		{"https://app.test", []string{"https://app.test"}, true},
+		{"https://evil.test", []string{"*"}, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+
+		if got := allowOrigin(r, tt.allowed); got != tt.want {
+			t.Errorf("allowOrigin(%q, %v) = %v, want %v", tt.origin, tt.allowed, got, tt.want)
+		}
+	}
+}
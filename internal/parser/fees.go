@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/buildwithme/ethparser/internal/rpcfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// FeeSummary totals what the outgoing transactions of an address cost, in wei.
+type FeeSummary struct {
+	Address      string
+	Transactions int
+	// Unpriced counts outgoing transactions stored without a receipt, whose
+	// fees are unknown and left out of the totals.
+	Unpriced     int
+	Value        string
+	Fees         string
+	BurnedFees   string
+	PriorityFees string
+	BlobFees     string
+	// TotalCost is Value + Fees + BlobFees: what left the account.
+	TotalCost string
+}
+
+// GetFeeSummary sums the value and fees of every external transaction sent by `address`.
+func (p *ethParser) GetFeeSummary(address string) FeeSummary {
+	var value, fees, burned, priority, blob big.Int
+
+	summary := FeeSummary{Address: strings.ToLower(address)}
+	for _, tx := range p.storage.GetTransactions(address, storage.TxFilter{}) {
+		if tx.Kind == storage.TX_KIND_INTERNAL || !strings.EqualFold(tx.From, address) {
+			continue
+		}
+
+		summary.Transactions++
+
+		// A reverted transaction pays its fees but moves no value.
+		if tx.ExecutionStatus != rpcfetch.RECEIPT_STATUS_FAILED {
+			addWei(&value, tx.Value)
+		}
+
+		if tx.Fee == "" {
+			summary.Unpriced++
+			continue
+		}
+
+		addWei(&fees, tx.Fee)
+		addWei(&burned, tx.BurnedFee)
+		addWei(&priority, tx.PriorityFee)
+		addWei(&blob, tx.BlobFee)
+	}
+
+	total := new(big.Int).Add(&value, &fees)
+	total.Add(total, &blob)
+
+	summary.Value = value.String()
+	summary.Fees = fees.String()
+	summary.BurnedFees = burned.String()
+	summary.PriorityFees = priority.String()
+	summary.BlobFees = blob.String()
+	summary.TotalCost = total.String()
+
+	return summary
+}
+
+// addWei adds the decimal quantity `value` to `sum`, ignoring empty values.
+func addWei(sum *big.Int, value string) {
+	n, ok := new(big.Int).SetString(value, 10)
+	if ok {
+		sum.Add(sum, n)
+	}
+}
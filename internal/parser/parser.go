@@ -1,46 +1,188 @@
 package parser
 
 import (
+	"context"
+
 	"github.com/buildwithme/ethparser/internal/blockfetch"
 	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/pkg/constants"
+	"github.com/buildwithme/ethparser/pkg/env"
 	"github.com/buildwithme/ethparser/pkg/logger"
 )
 
 type Parser interface {
 	// last parsed block
 	GetCurrentBlock() int
+	// blocks that failed after max retries, waiting for repair
+	GetGaps() []int
+	// latest chain tip and safe/finalized blocks
+	GetChainHead() blockfetch.ChainHead
 	// add address to observer
 	Subscribe(address string) bool
-	// list of inbound or outbound transactions for an address
-	GetTransactions(address string) []storage.Transaction
+	// add address to observer with a label and, with `backfill`, a start
+	// block from which its already processed history is backfilled
+	AddSubscription(sub storage.Subscription, backfill bool) bool
+	// progress of the history backfill of an address
+	GetBackfillStatus(address string) (blockfetch.BackfillStatus, bool)
+	// remove address from observer, keeping or dropping its history per RETAIN_UNSUBSCRIBED
+	Unsubscribe(address string) bool
+	// list of observed addresses with their metadata
+	ListSubscriptions() []storage.Subscription
+	// list of inbound or outbound transactions for an address, with their status
+	// and block header, narrowed by `filter`
+	GetTransactions(address string, filter storage.TxFilter) []Transaction
+	// one page of the transactions of an address, with their status
+	QueryTransactions(address string, query storage.TxQuery) (TransactionPage, error)
+	// list of ERC-20 transfers sent or received by an address
+	GetTokenTransfers(address string) []storage.TokenTransfer
+	// list of ERC-721 and ERC-1155 transfers sent or received by an address
+	GetNFTTransfers(address string) []storage.TokenTransfer
+	// value and fees paid by the outgoing transactions of an address
+	GetFeeSummary(address string) FeeSummary
+	// whether block fetching is caught up with a healthy upstream
+	GetReadiness(ctx context.Context) blockfetch.Readiness
+	// confirmation count and status of a stored transaction
+	Annotate(tx storage.Transaction) Transaction
+	// live events of new blocks, matched transactions and reorg removals,
+	// resumed after `lastEventID`
+	Stream(ctx context.Context, addresses []string, lastEventID string) (<-chan StreamEvent, error)
 }
 
 type ethParser struct {
-	log          *logger.Logger
-	storage      storage.Storage
-	blockFetcher blockfetch.BlockFetch
+	log           *logger.Logger
+	storage       storage.Storage
+	blockFetcher  blockfetch.BlockFetch
+	confirmations int
+	retain        bool
 }
 
 // NewParser constructs an ethParser that fetches blocks from `endpoint`.
 func NewParser(log *logger.Logger, sto storage.Storage, blockFetcher blockfetch.BlockFetch) Parser {
+	confirmations := env.GetEnvInt(constants.ENV_CONFIRMATIONS, 12)
+	retain := env.GetEnvBool(constants.ENV_RETAIN_UNSUBSCRIBED, true)
+
 	return &ethParser{
-		log:          log,
-		storage:      sto,
-		blockFetcher: blockFetcher,
+		log:           log,
+		storage:       sto,
+		blockFetcher:  blockFetcher,
+		confirmations: confirmations,
+		retain:        retain,
 	}
 }
 
-// GetCurrentBlock returns the highest block we've successfully written to Storage.
+// GetCurrentBlock returns the highest block below which every block has
+// been written to Storage.
 func (p *ethParser) GetCurrentBlock() int {
 	return p.blockFetcher.GetCurrentBlock()
 }
 
+// GetGaps proxies to the storage layer.
+func (p *ethParser) GetGaps() []int {
+	return p.storage.GetGaps()
+}
+
+// GetChainHead returns the latest observed chain tip and finality checkpoints.
+func (p *ethParser) GetChainHead() blockfetch.ChainHead {
+	return p.blockFetcher.GetChainHead()
+}
+
 // Subscribe proxies to the storage layer.
 func (p *ethParser) Subscribe(address string) bool {
-	return p.storage.SubscribeAddress(address)
+	return p.AddSubscription(storage.Subscription{Address: address}, false)
+}
+
+// AddSubscription subscribes sub.Address. Without `backfill` the address is
+// watched from the next block to be processed; with it, blocks
+// [StartBlock..last stored] are backfilled in the background, capped to the
+// last BACKFILL_MAX_BLOCKS blocks.
+func (p *ethParser) AddSubscription(sub storage.Subscription, backfill bool) bool {
+	if !backfill {
+		sub.StartBlock = p.blockFetcher.GetCurrentBlock() + 1
+	}
+
+	if !p.storage.SubscribeAddress(sub) {
+		return false
+	}
+
+	// Blocks stored from now on already include the address; the last
+	// stored block is read after subscribing so no block falls in between.
+	if end := p.storage.GetLastStoredBlock(); backfill && sub.StartBlock <= end {
+		p.blockFetcher.Backfill(sub.Address, sub.StartBlock, end)
+	}
+
+	return true
+}
+
+// GetBackfillStatus proxies to the block fetcher.
+func (p *ethParser) GetBackfillStatus(address string) (blockfetch.BackfillStatus, bool) {
+	return p.blockFetcher.GetBackfillStatus(address)
+}
+
+// Unsubscribe stops watching an address and cancels its backfill. Its
+// collected transactions are kept unless RETAIN_UNSUBSCRIBED is false.
+func (p *ethParser) Unsubscribe(address string) bool {
+	p.blockFetcher.CancelBackfill(address)
+	return p.storage.UnsubscribeAddress(address, !p.retain)
+}
+
+// GetReadiness proxies to the block fetcher.
+func (p *ethParser) GetReadiness(ctx context.Context) blockfetch.Readiness {
+	return p.blockFetcher.GetReadiness(ctx)
+}
+
+// ListSubscriptions proxies to the storage layer.
+func (p *ethParser) ListSubscriptions() []storage.Subscription {
+	return p.storage.ListSubscriptions()
 }
 
 // GetTransactions gets transactions for a specific address.
-func (p *ethParser) GetTransactions(address string) []storage.Transaction {
-	return p.storage.GetTransactions(address)
+func (p *ethParser) GetTransactions(address string, filter storage.TxFilter) []Transaction {
+	head := p.blockFetcher.GetChainHead()
+
+	stored := p.storage.GetTransactions(address, filter)
+	txs := make([]Transaction, 0, len(stored))
+	for _, tx := range stored {
+		txs = append(txs, p.withStatus(tx, head))
+	}
+
+	return txs
+}
+
+// QueryTransactions gets one page of transactions for a specific address.
+func (p *ethParser) QueryTransactions(address string, query storage.TxQuery) (TransactionPage, error) {
+	head := p.blockFetcher.GetChainHead()
+
+	page, err := p.storage.QueryTransactions(address, query)
+	if err != nil {
+		return TransactionPage{}, err
+	}
+
+	txs := make([]Transaction, 0, len(page.Transactions))
+	for _, tx := range page.Transactions {
+		txs = append(txs, p.withStatus(tx, head))
+	}
+
+	return TransactionPage{Transactions: txs, Next: page.Next}, nil
+}
+
+// GetTokenTransfers gets ERC-20 transfers for a specific address.
+func (p *ethParser) GetTokenTransfers(address string) []storage.TokenTransfer {
+	return p.filterTransfers(address, false)
+}
+
+// GetNFTTransfers gets ERC-721 and ERC-1155 transfers for a specific address.
+func (p *ethParser) GetNFTTransfers(address string) []storage.TokenTransfer {
+	return p.filterTransfers(address, true)
+}
+
+// filterTransfers returns the stored transfers of `address` that are (or aren't) NFTs.
+func (p *ethParser) filterTransfers(address string, nft bool) []storage.TokenTransfer {
+	transfers := []storage.TokenTransfer{}
+	for _, t := range p.storage.GetTokenTransfers(address) {
+		if t.IsNFT() == nft {
+			transfers = append(transfers, t)
+		}
+	}
+
+	return transfers
 }
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"github.com/buildwithme/ethparser/internal/blockfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+const (
+	STATUS_PENDING   = "pending-confirmations"
+	STATUS_CONFIRMED = "confirmed"
+	STATUS_SAFE      = "safe" // at or below the safe block
+	STATUS_FINALIZED = "finalized"
+	STATUS_REMOVED   = "removed" // rolled back by a reorg
+)
+
+// Transaction is a stored transaction annotated with how deeply it is buried.
+type Transaction struct {
+	storage.Transaction
+	Confirmations int
+	Status        string
+}
+
+// TransactionPage is one page of transactions with the cursor of the next
+// one, empty on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	Next         string        `json:"next,omitempty"`
+}
+
+// Annotate returns `tx` with its confirmation count and status at the
+// current chain head.
+func (p *ethParser) Annotate(tx storage.Transaction) Transaction {
+	return p.withStatus(tx, p.blockFetcher.GetChainHead())
+}
+
+// withStatus derives the confirmation count and status of `tx` from `head`.
+func (p *ethParser) withStatus(tx storage.Transaction, head blockfetch.ChainHead) Transaction {
+	confirmations := 0
+	if head.Latest >= tx.BlockNumber {
+		confirmations = head.Latest - tx.BlockNumber + 1
+	}
+
+	status := STATUS_PENDING
+	switch {
+	case head.Finalized >= 0 && tx.BlockNumber <= head.Finalized:
+		status = STATUS_FINALIZED
+	case head.Safe >= 0 && tx.BlockNumber <= head.Safe:
+		status = STATUS_SAFE
+	case confirmations >= p.confirmations:
+		status = STATUS_CONFIRMED
+	}
+
+	return Transaction{
+		Transaction:   tx,
+		Confirmations: confirmations,
+		Status:        status,
+	}
+}
@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/blockfetch"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+func TestWithStatus(t *testing.T) {
+	p := &ethParser{confirmations: 12}
+	head := blockfetch.ChainHead{Latest: 200, Safe: 150, Finalized: 100}
+
+	tests := []struct {
+		block int
+		head  blockfetch.ChainHead
+		want  string
+	}{
+		{195, head, STATUS_PENDING},
+		{180, head, STATUS_CONFIRMED},
+		{150, head, STATUS_SAFE},
+		{100, head, STATUS_FINALIZED},
+		{150, blockfetch.ChainHead{Latest: 200, Safe: -1, Finalized: -1}, STATUS_CONFIRMED},
+	}
+
+	for _, tt := range tests {
+		got := p.withStatus(storage.Transaction{BlockNumber: tt.block}, tt.head)
+		if got.Status != tt.want {
+			t.Errorf("block %d at head %+v: status %s, want %s", tt.block, tt.head, got.Status, tt.want)
+		}
+	}
+}
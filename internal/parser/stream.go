@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/buildwithme/ethparser/internal/events"
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// MAX_REPLAY_EVENTS bounds how many stored transactions a resumed stream
+// replays; a client further behind must resync from /transactions.
+const MAX_REPLAY_EVENTS = 1000
+
+// ErrReplayTooLarge is returned when more than MAX_REPLAY_EVENTS
+// transactions were stored after the last event of a resumed stream.
+var ErrReplayTooLarge = errors.New("too many events since the last event id; resync from /transactions")
+
+// StreamEvent is a new block, a matched transaction with its status, or a
+// transaction removed by a reorg. ID resumes the stream right after the event.
+type StreamEvent struct {
+	ID          string
+	Type        string // events.EVENT_BLOCK, events.EVENT_TRANSACTION or events.EVENT_REMOVED
+	Block       *storage.BlockHeader
+	Transaction *Transaction
+}
+
+// Stream returns the events of new blocks and of the transactions of
+// `addresses` (every subscribed address if empty). With a `lastEventID`,
+// the stored transactions after it are replayed first. The channel is
+// closed when `ctx` is done or the reader falls too far behind.
+func (p *ethParser) Stream(ctx context.Context, addresses []string, lastEventID string) (<-chan StreamEvent, error) {
+	sub := p.blockFetcher.Events().Subscribe(addresses)
+
+	var replay []storage.Transaction
+	if lastEventID != "" {
+		var err error
+		replay, err = p.replay(addresses, lastEventID)
+		if err != nil {
+			p.blockFetcher.Events().Unsubscribe(sub)
+			return nil, err
+		}
+	}
+
+	out := make(chan StreamEvent)
+	go func() {
+		defer close(out)
+		defer p.blockFetcher.Events().Unsubscribe(sub)
+
+		send := func(ev StreamEvent) bool {
+			select {
+			case out <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		last := lastEventID
+		head := p.blockFetcher.GetChainHead()
+		for _, tx := range replay {
+			t := p.withStatus(tx, head)
+			last = storage.Cursor(tx)
+			if !send(StreamEvent{ID: last, Type: events.EVENT_TRANSACTION, Transaction: &t}) {
+				return
+			}
+		}
+
+		for {
+			select {
+			case ev, ok := <-sub.C:
+				if !ok {
+					return
+				}
+
+				// Skip what the replay already covered.
+				if last != "" && ev.Type != events.EVENT_REMOVED {
+					if cmp, err := storage.CompareCursors(ev.ID, last); err == nil && cmp <= 0 {
+						continue
+					}
+				}
+
+				if !send(p.toStreamEvent(ev)) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// replay returns the stored transactions of `addresses` (every subscribed
+// address if empty) after the `cursor`, in order and without duplicates.
+// Fails with ErrReplayTooLarge past MAX_REPLAY_EVENTS transactions.
+func (p *ethParser) replay(addresses []string, cursor string) ([]storage.Transaction, error) {
+	if len(addresses) == 0 {
+		addresses = p.storage.GetSubscribedAddresses()
+	}
+
+	seen := make(map[string]bool)
+	var txs []storage.Transaction
+	for _, a := range addresses {
+		page, err := p.storage.QueryTransactions(strings.ToLower(a), storage.TxQuery{Cursor: cursor, Limit: MAX_REPLAY_EVENTS})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tx := range page.Transactions {
+			id := storage.Cursor(tx)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			txs = append(txs, tx)
+		}
+
+		if page.Next != "" || len(txs) > MAX_REPLAY_EVENTS {
+			return nil, ErrReplayTooLarge
+		}
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool {
+		cmp, _ := storage.CompareCursors(storage.Cursor(txs[i]), storage.Cursor(txs[j]))
+		return cmp < 0
+	})
+
+	return txs, nil
+}
+
+// toStreamEvent annotates a bus event with the current chain head.
+func (p *ethParser) toStreamEvent(ev events.Event) StreamEvent {
+	switch ev.Type {
+	case events.EVENT_TRANSACTION:
+		t := p.Annotate(ev.Transaction)
+		return StreamEvent{ID: ev.ID, Type: ev.Type, Transaction: &t}
+	case events.EVENT_REMOVED:
+		t := Transaction{Transaction: ev.Transaction, Status: STATUS_REMOVED}
+		return StreamEvent{ID: ev.ID, Type: ev.Type, Transaction: &t}
+	default:
+		return StreamEvent{ID: ev.ID, Type: ev.Type, Block: &ev.Block}
+	}
+}
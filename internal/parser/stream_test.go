@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+func TestReplayWindow(t *testing.T) {
+	const addr = "0x00000000000000000000000000000000000000a1"
+
+	tests := []struct {
+		name    string
+		stored  int
+		wantErr error
+	}{
+		{"within the window", 3, nil},
+		{"exactly the window", MAX_REPLAY_EVENTS, nil},
+		{"past the window", MAX_REPLAY_EVENTS + 1, ErrReplayTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sto := storage.NewMemoryStorage()
+			sto.SubscribeAddress(storage.Subscription{Address: addr})
+			for n := 1; n <= tt.stored+1; n++ {
+				tx := storage.Transaction{Hash: fmt.Sprintf("0x%x", n), From: addr, BlockNumber: n, Value: "0"}
+				sto.StoreBlockTransactions(storage.BlockHeader{Number: n}, []storage.Transaction{tx}, nil)
+			}
+
+			p := &ethParser{storage: sto}
+			txs, err := p.replay(nil, storage.BlockCursor(1))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if len(txs) != tt.stored {
+				t.Fatalf("replayed %d transactions, want %d", len(txs), tt.stored)
+			}
+			for i, tx := range txs {
+				if tx.BlockNumber != i+2 {
+					t.Fatalf("transaction %d is from block %d, want %d", i, tx.BlockNumber, i+2)
+				}
+			}
+		})
+	}
+}
@@ -1,22 +1,14 @@
 package rpcfetch
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"math/big"
-	"net/http"
 	"strconv"
 )
 
 const (
-	TRANSACTION_BLOCK      = "blockNumber"
-	TRANSACTION_HASH       = "hash"
-	TRANSACTION_FROM       = "from"
-	TRANSACTION_TO         = "to"
-	TRANSACTION_VALUE      = "value"
 	JSON_RPC_VERSION       = "2.0"
 	BLOCK_BY_NUMBER_METHOD = "eth_getBlockByNumber"
 )
@@ -24,8 +16,15 @@ const (
 type (
 	// BlockByNumberResultResponse captures the response from the Ethereum node.
 	BlockByNumberResultResponse struct {
-		Number       string           `json:"number"`
-		Transactions []map[string]any `json:"transactions"`
+		Number       string                      `json:"number"`
+		Hash         string                      `json:"hash"`
+		ParentHash   string                      `json:"parentHash"`
+		BaseFee      string                      `json:"baseFeePerGas"`
+		Timestamp    string                      `json:"timestamp"`
+		Miner        string                      `json:"miner"`
+		GasUsed      string                      `json:"gasUsed"`
+		GasLimit     string                      `json:"gasLimit"`
+		Transactions []TransactionResultResponse `json:"transactions"`
 	}
 
 	// BlockByNumberResponse captures the response from the Ethereum node.
@@ -38,101 +37,63 @@ type (
 
 // fetchBlock fetches a block from the Ethereum node and returns transactions.
 func (p *ethFetcher) FetchBlock(ctx context.Context, blockNum int) (*BlockResult, error) {
-	hexBlock := fmt.Sprintf("0x%X", blockNum)
-	payload := map[string]interface{}{
-		"jsonrpc": JSON_RPC_VERSION,
-		"method":  BLOCK_BY_NUMBER_METHOD,
-		"params":  []interface{}{hexBlock, true},
-		"id":      1,
-	}
-
-	resp, err := p.postRequest(ctx, payload)
-	if err != nil {
-		return nil, err
-	}
-
 	var response BlockByNumberResponse
-	err = p.decode(resp, &response)
+	err := p.call(ctx, blockByNumberRequest(blockNum, p.nextIDs(1)), &response)
 	if err != nil {
 		return nil, err
 	}
 
-	var txs []*BlockTransaction
-	for _, raw := range response.Result.Transactions {
-		txValue := getTrxStringValue(raw, TRANSACTION_VALUE)
-
-		txs = append(txs, &BlockTransaction{
-			BlockNumber: int(getTrxIntValue(raw, TRANSACTION_BLOCK)),
-			Hash:        getTrxStringValue(raw, TRANSACTION_HASH),
-			From:        getTrxStringValue(raw, TRANSACTION_FROM),
-			To:          getTrxStringValue(raw, TRANSACTION_TO),
-			Value:       p.getBigIntValue(txValue),
-		})
-	}
-
-	return &BlockResult{BlockNumber: blockNum, Transactions: txs}, nil
+	return p.toBlockResult(blockNum, response.Result)
 }
 
-func (p *ethFetcher) getBigIntValue(value string) string {
-	bi := new(big.Int)
-	_, ok := bi.SetString(value[2:], 16)
-	if !ok {
-		log.Printf("[ERROR]: Failed to parse %s as hex", value)
-		return ""
+// blockByNumberRequest builds an eth_getBlockByNumber call with full transactions.
+func blockByNumberRequest(blockNum int, id int) RPCRequest {
+	return RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  BLOCK_BY_NUMBER_METHOD,
+		Params:  []interface{}{fmt.Sprintf("0x%X", blockNum), true},
+		ID:      id,
 	}
-
-	return bi.String()
 }
 
-// getTrxStringValue returns the string value from the raw transaction data.
-func getTrxStringValue(raw map[string]any, value string) string {
-	data, ok := raw[value].(string)
-	if !ok {
-		return ""
+// toBlockResult maps a decoded block onto a BlockResult.
+func (p *ethFetcher) toBlockResult(blockNum int, result BlockByNumberResultResponse) (*BlockResult, error) {
+	// A null result means the node doesn't have this block (yet).
+	if result.Hash == "" {
+		return nil, fmt.Errorf("block %d not found", blockNum)
 	}
 
-	return data
-}
-
-// getTrxIntValue returns the integer value from the raw transaction data.
-func getTrxIntValue(raw map[string]any, value string) int64 {
-	bn, _ := strconv.ParseInt(getTrxStringValue(raw, value), 0, 64)
-	return bn
-}
-
-// fetchLatestBlock gets the chain tip from Ethereum node.
-func (p *ethFetcher) postRequest(ctx context.Context, payload any) (*http.Response, error) {
-	reqBody, err := json.Marshal(payload)
-	if err != nil {
-		return nil, err
-	}
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewReader(reqBody))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
+	var txs []*BlockTransaction
+	for _, raw := range result.Transactions {
+		txs = append(txs, p.toBlockTransaction(raw))
 	}
 
-	return resp, nil
+	timestamp, _ := strconv.ParseInt(result.Timestamp, 0, 64)
+
+	return &BlockResult{
+		BlockNumber:  blockNum,
+		Hash:         result.Hash,
+		ParentHash:   result.ParentHash,
+		BaseFee:      p.getBigIntValue(result.BaseFee),
+		Timestamp:    timestamp,
+		Miner:        result.Miner,
+		GasUsed:      p.getBigIntValue(result.GasUsed),
+		GasLimit:     p.getBigIntValue(result.GasLimit),
+		Transactions: txs,
+	}, nil
 }
 
-// GetLatestBlock returns the latest block number from the Ethereum node.
-func (p *ethFetcher) decode(resp *http.Response, output any) error {
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("status code %d", resp.StatusCode)
+func (p *ethFetcher) getBigIntValue(value string) string {
+	if len(value) < 2 {
+		return ""
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
-		return err
+	bi := new(big.Int)
+	_, ok := bi.SetString(value[2:], 16)
+	if !ok {
+		log.Printf("[ERROR]: Failed to parse %s as hex", value)
+		return ""
 	}
 
-	return nil
+	return bi.String()
 }
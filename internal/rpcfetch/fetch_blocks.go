@@ -0,0 +1,70 @@
+package rpcfetch
+
+import (
+	"context"
+	"fmt"
+)
+
+type (
+	// BatchBlockResponse is one element of a batched eth_getBlockByNumber response.
+	BatchBlockResponse struct {
+		ID     int                          `json:"id"`
+		Result *BlockByNumberResultResponse `json:"result"`
+		Error  *RPCError                    `json:"error"`
+	}
+)
+
+// FetchBlocks fetches several blocks in a single JSON-RPC batch request.
+// Results are returned in the order of `blockNums`.
+func (p *ethFetcher) FetchBlocks(ctx context.Context, blockNums []int) ([]*BlockResult, error) {
+	baseID := p.nextIDs(len(blockNums))
+
+	payload := make([]RPCRequest, 0, len(blockNums))
+	for i, b := range blockNums {
+		payload = append(payload, blockByNumberRequest(b, baseID+i))
+	}
+
+	var responses []BatchBlockResponse
+	err := p.call(ctx, payload, &responses)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map each response back to its block via the request id.
+	results := make([]*BlockResult, len(blockNums))
+	for _, r := range responses {
+		idx := r.ID - baseID
+		if idx < 0 || idx >= len(blockNums) {
+			continue
+		}
+
+		blockNum := blockNums[idx]
+		if r.Error != nil {
+			results[idx] = &BlockResult{BlockNumber: blockNum, Err: r.Error}
+			continue
+		}
+
+		var block BlockByNumberResultResponse
+		if r.Result != nil {
+			block = *r.Result
+		}
+
+		result, err := p.toBlockResult(blockNum, block)
+		if err != nil {
+			result = &BlockResult{BlockNumber: blockNum, Err: err}
+		}
+
+		results[idx] = result
+	}
+
+	for i, r := range results {
+		if r == nil {
+			results[i] = &BlockResult{
+				BlockNumber: blockNums[i],
+				Err:         fmt.Errorf("block %d missing from batch response", blockNums[i]),
+			}
+		}
+	}
+
+	return results, nil
+}
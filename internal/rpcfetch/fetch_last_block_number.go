@@ -9,10 +9,10 @@ const BLOCK_NUMBER_METHOD = "eth_blockNumber"
 
 type (
 	RPCRequest struct {
-		JSONRPC string `json:"jsonrpc"`
-		Method  string `json:"method"`
-		Params  []interface{}
-		ID      int `json:"id"`
+		JSONRPC string        `json:"jsonrpc"`
+		Method  string        `json:"method"`
+		Params  []interface{} `json:"params"`
+		ID      int           `json:"id"`
 	}
 
 	BlockNumberResponse struct {
@@ -26,16 +26,11 @@ func (p *ethFetcher) GetLatestBlock(ctx context.Context) (int, error) {
 		JSONRPC: JSON_RPC_VERSION,
 		Method:  BLOCK_NUMBER_METHOD,
 		Params:  []interface{}{},
-		ID:      1,
-	}
-
-	resp, err := p.postRequest(ctx, payload)
-	if err != nil {
-		return 0, err
+		ID:      p.nextIDs(1),
 	}
 
 	var response BlockNumberResponse
-	err = p.decode(resp, &response)
+	err := p.call(ctx, payload, &response)
 	if err != nil {
 		return 0, err
 	}
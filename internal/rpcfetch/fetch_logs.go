@@ -0,0 +1,109 @@
+package rpcfetch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const GET_LOGS_METHOD = "eth_getLogs"
+
+type (
+	// LogFilter selects logs either by block range or by block hash.
+	// Topics are ANDed by position; the values at one position are ORed,
+	// and a nil position matches anything.
+	LogFilter struct {
+		FromBlock int
+		ToBlock   int
+		BlockHash string
+		Topics    [][]string
+	}
+
+	// Log is a decoded event log.
+	Log struct {
+		Address     string
+		Topics      []string
+		Data        string
+		BlockNumber int
+		BlockHash   string
+		TxHash      string
+		LogIndex    int
+	}
+
+	// LogResultResponse captures a log as returned by the node.
+	LogResultResponse struct {
+		Address         string   `json:"address"`
+		Topics          []string `json:"topics"`
+		Data            string   `json:"data"`
+		BlockNumber     string   `json:"blockNumber"`
+		BlockHash       string   `json:"blockHash"`
+		TransactionHash string   `json:"transactionHash"`
+		LogIndex        string   `json:"logIndex"`
+		Removed         bool     `json:"removed"`
+	}
+
+	// LogsResponse captures the response of eth_getLogs.
+	LogsResponse struct {
+		Result []LogResultResponse `json:"result"`
+	}
+)
+
+// FetchLogs returns the logs matching `filter`.
+func (p *ethFetcher) FetchLogs(ctx context.Context, filter LogFilter) ([]*Log, error) {
+	payload := RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  GET_LOGS_METHOD,
+		Params:  []interface{}{filter.params()},
+		ID:      p.nextIDs(1),
+	}
+
+	var response LogsResponse
+	err := p.call(ctx, payload, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make([]*Log, 0, len(response.Result))
+	for _, l := range response.Result {
+		if l.Removed {
+			continue
+		}
+
+		blockNumber, _ := strconv.ParseInt(l.BlockNumber, 0, 64)
+		logIndex, _ := strconv.ParseInt(l.LogIndex, 0, 64)
+
+		logs = append(logs, &Log{
+			Address:     l.Address,
+			Topics:      l.Topics,
+			Data:        l.Data,
+			BlockNumber: int(blockNumber),
+			BlockHash:   l.BlockHash,
+			TxHash:      l.TransactionHash,
+			LogIndex:    int(logIndex),
+		})
+	}
+
+	return logs, nil
+}
+
+// params encodes the filter as the eth_getLogs filter object.
+func (f LogFilter) params() map[string]any {
+	topics := make([]any, 0, len(f.Topics))
+	for _, t := range f.Topics {
+		if t == nil {
+			topics = append(topics, nil)
+			continue
+		}
+		topics = append(topics, t)
+	}
+
+	params := map[string]any{"topics": topics}
+	if f.BlockHash != "" {
+		params["blockHash"] = f.BlockHash
+	} else {
+		params["fromBlock"] = fmt.Sprintf("0x%X", f.FromBlock)
+		params["toBlock"] = fmt.Sprintf("0x%X", f.ToBlock)
+	}
+
+	return params
+}
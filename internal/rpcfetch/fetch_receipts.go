@@ -0,0 +1,167 @@
+package rpcfetch
+
+import (
+	"context"
+	"fmt"
+)
+
+const (
+	BLOCK_RECEIPTS_METHOD      = "eth_getBlockReceipts"
+	TRANSACTION_RECEIPT_METHOD = "eth_getTransactionReceipt"
+
+	RECEIPT_STATUS_SUCCESS = "success"
+	RECEIPT_STATUS_FAILED  = "failed"
+)
+
+type (
+	// Receipt is the execution outcome of a transaction.
+	Receipt struct {
+		TransactionHash   string
+		Status            string // RECEIPT_STATUS_SUCCESS or RECEIPT_STATUS_FAILED
+		GasUsed           string // decimal
+		EffectiveGasPrice string // decimal, in wei
+		ContractAddress   string // set for contract creations
+		BlobGasUsed       string // decimal, blob transactions only
+		BlobGasPrice      string // decimal, in wei, blob transactions only
+	}
+
+	// ReceiptResultResponse captures a receipt as returned by the node.
+	ReceiptResultResponse struct {
+		TransactionHash   string  `json:"transactionHash"`
+		Status            string  `json:"status"`
+		GasUsed           string  `json:"gasUsed"`
+		EffectiveGasPrice string  `json:"effectiveGasPrice"`
+		ContractAddress   *string `json:"contractAddress"`
+		BlobGasUsed       string  `json:"blobGasUsed"`
+		BlobGasPrice      string  `json:"blobGasPrice"`
+	}
+
+	// BlockReceiptsResponse captures the response of eth_getBlockReceipts.
+	BlockReceiptsResponse struct {
+		Result []ReceiptResultResponse `json:"result"`
+	}
+
+	// BatchReceiptResponse is one element of a batched eth_getTransactionReceipt response.
+	BatchReceiptResponse struct {
+		ID     int                    `json:"id"`
+		Result *ReceiptResultResponse `json:"result"`
+		Error  *RPCError              `json:"error"`
+	}
+)
+
+// FetchReceipts returns the receipts of `txHashes` in `blockNum`, keyed by
+// transaction hash. It uses eth_getBlockReceipts and falls back to batched
+// eth_getTransactionReceipt calls on endpoints that don't support it.
+func (p *ethFetcher) FetchReceipts(ctx context.Context, blockNum int, txHashes []string) (map[string]*Receipt, error) {
+	if len(txHashes) == 0 {
+		return map[string]*Receipt{}, nil
+	}
+
+	if !p.noBlockReceipts.Load() {
+		receipts, err := p.fetchBlockReceipts(ctx, blockNum)
+		if err == nil {
+			return receipts, nil
+		}
+
+		// Throttling and other transient errors are retried by the caller
+		if !IsMethodUnsupported(err) {
+			return nil, err
+		}
+
+		p.log.Printf("[WARN] %s unsupported on %s (%v); using %s",
+			BLOCK_RECEIPTS_METHOD, p.endpoint, err, TRANSACTION_RECEIPT_METHOD)
+		p.noBlockReceipts.Store(true)
+	}
+
+	return p.fetchTransactionReceipts(ctx, txHashes)
+}
+
+// fetchBlockReceipts fetches every receipt of a block in one call.
+func (p *ethFetcher) fetchBlockReceipts(ctx context.Context, blockNum int) (map[string]*Receipt, error) {
+	payload := RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  BLOCK_RECEIPTS_METHOD,
+		Params:  []interface{}{fmt.Sprintf("0x%X", blockNum)},
+		ID:      p.nextIDs(1),
+	}
+
+	var response BlockReceiptsResponse
+	err := p.call(ctx, payload, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := make(map[string]*Receipt, len(response.Result))
+	for _, r := range response.Result {
+		receipt := p.toReceipt(r)
+		receipts[receipt.TransactionHash] = receipt
+	}
+
+	return receipts, nil
+}
+
+// fetchTransactionReceipts fetches the receipts of `txHashes` in one batch.
+func (p *ethFetcher) fetchTransactionReceipts(ctx context.Context, txHashes []string) (map[string]*Receipt, error) {
+	baseID := p.nextIDs(len(txHashes))
+
+	payload := make([]RPCRequest, 0, len(txHashes))
+	for i, h := range txHashes {
+		payload = append(payload, RPCRequest{
+			JSONRPC: JSON_RPC_VERSION,
+			Method:  TRANSACTION_RECEIPT_METHOD,
+			Params:  []interface{}{h},
+			ID:      baseID + i,
+		})
+	}
+
+	var responses []BatchReceiptResponse
+	err := p.call(ctx, payload, &responses)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := make(map[string]*Receipt, len(txHashes))
+	for _, r := range responses {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+
+		if r.Result == nil {
+			continue
+		}
+
+		receipt := p.toReceipt(*r.Result)
+		receipts[receipt.TransactionHash] = receipt
+	}
+
+	for _, h := range txHashes {
+		if receipts[h] == nil {
+			return nil, fmt.Errorf("receipt for %s not found", h)
+		}
+	}
+
+	return receipts, nil
+}
+
+// toReceipt decodes the hex quantities of a node receipt.
+func (p *ethFetcher) toReceipt(r ReceiptResultResponse) *Receipt {
+	status := RECEIPT_STATUS_FAILED
+	if r.Status == "0x1" {
+		status = RECEIPT_STATUS_SUCCESS
+	}
+
+	receipt := &Receipt{
+		TransactionHash:   r.TransactionHash,
+		Status:            status,
+		GasUsed:           p.getBigIntValue(r.GasUsed),
+		EffectiveGasPrice: p.getBigIntValue(r.EffectiveGasPrice),
+		BlobGasUsed:       p.getBigIntValue(r.BlobGasUsed),
+		BlobGasPrice:      p.getBigIntValue(r.BlobGasPrice),
+	}
+
+	if r.ContractAddress != nil {
+		receipt.ContractAddress = *r.ContractAddress
+	}
+
+	return receipt
+}
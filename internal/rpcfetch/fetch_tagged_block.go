@@ -0,0 +1,64 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	BLOCK_TAG_LATEST    = "latest"
+	BLOCK_TAG_SAFE      = "safe"
+	BLOCK_TAG_FINALIZED = "finalized"
+)
+
+// RPC_INVALID_PARAMS is the JSON-RPC error code some nodes return for a
+// block tag they don't know.
+const RPC_INVALID_PARAMS = -32602
+
+// ErrTagUnsupported is returned by GetTaggedBlock when the endpoint has no
+// block for the tag, e.g. before the merge or on a chain without finality.
+var ErrTagUnsupported = errors.New("block tag not supported by endpoint")
+
+type (
+	// TaggedBlockResponse captures just the number of a block fetched by tag.
+	TaggedBlockResponse struct {
+		Result *struct {
+			Number string `json:"number"`
+		} `json:"result"`
+	}
+)
+
+// GetTaggedBlock returns the number of the block the node reports for `tag`
+// (e.g. "safe" or "finalized").
+func (p *ethFetcher) GetTaggedBlock(ctx context.Context, tag string) (int, error) {
+	payload := RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  BLOCK_BY_NUMBER_METHOD,
+		Params:  []interface{}{tag, false},
+		ID:      p.nextIDs(1),
+	}
+
+	var response TaggedBlockResponse
+	err := p.call(ctx, payload, &response)
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && rpcErr.Code == RPC_INVALID_PARAMS {
+		return 0, fmt.Errorf("%w: %q (%v)", ErrTagUnsupported, tag, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if response.Result == nil {
+		return 0, fmt.Errorf("%w: %q", ErrTagUnsupported, tag)
+	}
+
+	val, err := strconv.ParseInt(response.Result.Number, 0, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(val), nil
+}
@@ -0,0 +1,259 @@
+package rpcfetch
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	DEBUG_TRACE_BLOCK_METHOD = "debug_traceBlockByNumber"
+	TRACE_BLOCK_METHOD       = "trace_block"
+	CALL_TRACER              = "callTracer"
+
+	CALL_TYPE_CALL         = "CALL"
+	CALL_TYPE_CREATE       = "CREATE"
+	CALL_TYPE_SELFDESTRUCT = "SELFDESTRUCT"
+	CALL_TYPE_DELEGATECALL = "DELEGATECALL"
+	CALL_TYPE_STATICCALL   = "STATICCALL"
+)
+
+type (
+	// InternalCall is a value transfer made by a contract during the
+	// execution of a transaction rather than by the transaction itself.
+	InternalCall struct {
+		TxHash       string // empty when the node didn't report it; see TxIndex
+		TxIndex      int
+		TraceAddress string // position in the call tree, e.g. "0-2"
+		Type         string // CALL, CREATE, SELFDESTRUCT...
+		From         string
+		To           string
+		Value        string // decimal, in wei
+	}
+
+	// CallFrame is one frame of a callTracer trace.
+	CallFrame struct {
+		Type  string      `json:"type"`
+		From  string      `json:"from"`
+		To    string      `json:"to"`
+		Value string      `json:"value"`
+		Error string      `json:"error"`
+		Calls []CallFrame `json:"calls"`
+	}
+
+	// TxTraceResponse is the callTracer trace of one transaction. Older
+	// nodes leave txHash out, in which case traces follow the block order.
+	TxTraceResponse struct {
+		TxHash string     `json:"txHash"`
+		Result *CallFrame `json:"result"`
+	}
+
+	// DebugTraceBlockResponse captures the response of debug_traceBlockByNumber.
+	DebugTraceBlockResponse struct {
+		Result []TxTraceResponse `json:"result"`
+	}
+
+	// ParityTraceResponse is one flattened trace of trace_block.
+	ParityTraceResponse struct {
+		Type   string `json:"type"` // call, create, suicide or reward
+		Action struct {
+			CallType      string `json:"callType"`
+			From          string `json:"from"`
+			To            string `json:"to"`
+			Value         string `json:"value"`
+			Address       string `json:"address"`
+			RefundAddress string `json:"refundAddress"`
+			Balance       string `json:"balance"`
+		} `json:"action"`
+		Result *struct {
+			Address string `json:"address"`
+		} `json:"result"`
+		TraceAddress        []int  `json:"traceAddress"`
+		TransactionHash     string `json:"transactionHash"`
+		TransactionPosition int    `json:"transactionPosition"`
+		Error               string `json:"error"`
+	}
+
+	// TraceBlockResponse captures the response of trace_block.
+	TraceBlockResponse struct {
+		Result []ParityTraceResponse `json:"result"`
+	}
+)
+
+// FetchTraces returns the value-bearing internal calls of `blockNum`. It
+// uses debug_traceBlockByNumber with the callTracer and falls back to
+// trace_block on endpoints that don't support it. Calls that reverted, and
+// the top-level call of each transaction, are left out.
+func (p *ethFetcher) FetchTraces(ctx context.Context, blockNum int) ([]*InternalCall, error) {
+	if !p.noDebugTrace.Load() {
+		calls, err := p.fetchDebugTraces(ctx, blockNum)
+		if err == nil {
+			return calls, nil
+		}
+
+		// Throttling and timeouts are retried by the caller; geth, for one,
+		// doesn't serve trace_block at all
+		if !IsMethodUnsupported(err) {
+			return nil, err
+		}
+
+		p.log.Printf("[WARN] %s unsupported on %s (%v); using %s",
+			DEBUG_TRACE_BLOCK_METHOD, p.endpoint, err, TRACE_BLOCK_METHOD)
+		p.noDebugTrace.Store(true)
+	}
+
+	return p.fetchParityTraces(ctx, blockNum)
+}
+
+// fetchDebugTraces traces a block with debug_traceBlockByNumber.
+func (p *ethFetcher) fetchDebugTraces(ctx context.Context, blockNum int) ([]*InternalCall, error) {
+	payload := RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  DEBUG_TRACE_BLOCK_METHOD,
+		Params:  []interface{}{fmt.Sprintf("0x%X", blockNum), map[string]string{"tracer": CALL_TRACER}},
+		ID:      p.nextIDs(1),
+	}
+
+	var response DebugTraceBlockResponse
+	err := p.call(ctx, payload, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []*InternalCall
+	for i, t := range response.Result {
+		// A reverted transaction moved no value at all.
+		if t.Result == nil || t.Result.Error != "" {
+			continue
+		}
+
+		for j, frame := range t.Result.Calls {
+			calls = p.collectFrames(calls, t.TxHash, i, strconv.Itoa(j), frame)
+		}
+	}
+
+	return calls, nil
+}
+
+// collectFrames appends `frame` and its sub-calls to `calls`, skipping
+// reverted subtrees and calls that can't move value.
+func (p *ethFetcher) collectFrames(calls []*InternalCall, txHash string, txIndex int, traceAddress string, frame CallFrame) []*InternalCall {
+	if frame.Error != "" {
+		return calls
+	}
+
+	callType := strings.ToUpper(frame.Type)
+	if callType != CALL_TYPE_DELEGATECALL && callType != CALL_TYPE_STATICCALL {
+		calls = p.appendInternalCall(calls, &InternalCall{
+			TxHash:       txHash,
+			TxIndex:      txIndex,
+			TraceAddress: traceAddress,
+			Type:         callType,
+			From:         frame.From,
+			To:           frame.To,
+			Value:        frame.Value,
+		})
+	}
+
+	for j, sub := range frame.Calls {
+		calls = p.collectFrames(calls, txHash, txIndex, fmt.Sprintf("%s-%d", traceAddress, j), sub)
+	}
+
+	return calls
+}
+
+// fetchParityTraces traces a block with trace_block.
+func (p *ethFetcher) fetchParityTraces(ctx context.Context, blockNum int) ([]*InternalCall, error) {
+	payload := RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  TRACE_BLOCK_METHOD,
+		Params:  []interface{}{fmt.Sprintf("0x%X", blockNum)},
+		ID:      p.nextIDs(1),
+	}
+
+	var response TraceBlockResponse
+	err := p.call(ctx, payload, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	// Traces come parent first, so a reverted frame is always seen before
+	// the sub-calls it takes down with it.
+	reverted := make(map[string]bool)
+
+	var calls []*InternalCall
+	for _, t := range response.Result {
+		if t.Type == "reward" {
+			continue
+		}
+
+		parts := make([]string, len(t.TraceAddress))
+		for i, n := range t.TraceAddress {
+			parts[i] = strconv.Itoa(n)
+		}
+		traceAddress := strings.Join(parts, "-")
+
+		if t.Error != "" {
+			reverted[t.TransactionHash+":"+traceAddress] = true
+			continue
+		}
+
+		if isRevertedTrace(reverted, t.TransactionHash, parts) || len(parts) == 0 {
+			continue
+		}
+
+		call := &InternalCall{
+			TxHash:       t.TransactionHash,
+			TxIndex:      t.TransactionPosition,
+			TraceAddress: traceAddress,
+		}
+
+		switch t.Type {
+		case "call":
+			call.Type = strings.ToUpper(t.Action.CallType)
+			call.From, call.To, call.Value = t.Action.From, t.Action.To, t.Action.Value
+			if call.Type == CALL_TYPE_DELEGATECALL || call.Type == CALL_TYPE_STATICCALL {
+				continue
+			}
+		case "create":
+			call.Type = CALL_TYPE_CREATE
+			call.From, call.Value = t.Action.From, t.Action.Value
+			if t.Result != nil {
+				call.To = t.Result.Address
+			}
+		case "suicide":
+			call.Type = CALL_TYPE_SELFDESTRUCT
+			call.From, call.To, call.Value = t.Action.Address, t.Action.RefundAddress, t.Action.Balance
+		default:
+			continue
+		}
+
+		calls = p.appendInternalCall(calls, call)
+	}
+
+	return calls, nil
+}
+
+// isRevertedTrace reports whether the trace at `parts`, or any of its
+// ancestors, reverted.
+func isRevertedTrace(reverted map[string]bool, txHash string, parts []string) bool {
+	for i := 0; i <= len(parts); i++ {
+		if reverted[txHash+":"+strings.Join(parts[:i], "-")] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// appendInternalCall decodes the hex value of `call` and appends it to
+// `calls` when it actually moves ether.
+func (p *ethFetcher) appendInternalCall(calls []*InternalCall, call *InternalCall) []*InternalCall {
+	call.Value = p.getBigIntValue(call.Value)
+	if call.Value == "" || call.Value == "0" {
+		return calls
+	}
+
+	return append(calls, call)
+}
@@ -0,0 +1,53 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+
+	"github.com/buildwithme/ethparser/pkg/metrics"
+)
+
+const (
+	CALL_STATUS_OK       = "ok"
+	CALL_STATUS_ERROR    = "error"
+	CALL_STATUS_CANCELED = "canceled"
+	CALL_STATUS_LIMITED  = "rate_limited"
+)
+
+var (
+	rpcRequests = metrics.NewCounterVec("ethparser_rpc_requests_total",
+		"JSON-RPC requests sent, by method and status (ok, error, rate_limited or canceled).", "method", "status")
+	rpcDuration = metrics.NewHistogramVec("ethparser_rpc_request_duration_seconds",
+		"JSON-RPC round-trip latency, by method.", nil, "method")
+)
+
+// methodOf returns the method of a request or batch payload and how many
+// requests it carries.
+func methodOf(payload any) (string, int) {
+	switch p := payload.(type) {
+	case RPCRequest:
+		return p.Method, 1
+	case []RPCRequest:
+		if len(p) > 0 {
+			return p[0].Method, len(p)
+		}
+	}
+
+	return "unknown", 1
+}
+
+// callStatus classifies the outcome of a call.
+func callStatus(err error) string {
+	switch {
+	case err == nil:
+		return CALL_STATUS_OK
+	case errors.Is(err, context.Canceled):
+		return CALL_STATUS_CANCELED
+	}
+
+	if _, limited := IsRateLimited(err); limited {
+		return CALL_STATUS_LIMITED
+	}
+
+	return CALL_STATUS_ERROR
+}
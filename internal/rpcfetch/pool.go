@@ -0,0 +1,389 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/buildwithme/ethparser/pkg/constants"
+	"github.com/buildwithme/ethparser/pkg/env"
+	"github.com/buildwithme/ethparser/pkg/logger"
+)
+
+const (
+	STRATEGY_ROUND_ROBIN = "round-robin"
+	STRATEGY_LATENCY     = "latency"
+
+	// MAX_CONSECUTIVE_ERRORS ejects an endpoint after this many failures in a row.
+	MAX_CONSECUTIVE_ERRORS = 3
+
+	HEALTH_CHECK_INTERVAL = 10 * time.Second
+	HEALTH_CHECK_TIMEOUT  = 5 * time.Second
+)
+
+// errNoUpstream is returned when the pool has no endpoint left to try.
+var errNoUpstream = errors.New("no RPC endpoint available")
+
+type (
+	// upstream is one endpoint of the pool with its health-check state.
+	upstream struct {
+		fetcher     *ethFetcher
+		healthy     bool
+		latestBlock int
+	}
+
+	// pin holds the endpoint the requests of a context stick to.
+	// Guarded by the pool's mu.
+	pin struct {
+		upstream *upstream
+	}
+
+	// pinKey is the context key of a pin.
+	pinKey struct{}
+
+	// poolFetcher spreads requests over several endpoints, retrying on the
+	// next one when a request fails.
+	poolFetcher struct {
+		log       *logger.Logger
+		strategy  string
+		maxLag    int
+		mu        sync.Mutex
+		upstreams []*upstream
+		next      int
+	}
+)
+
+// newPoolFetcher constructs a poolFetcher over `endpoints` and starts
+// health-checking them in the background until `ctx` is done.
+func newPoolFetcher(ctx context.Context, log *logger.Logger, endpoints []string) *poolFetcher {
+	strategy := env.GetEnvString(constants.ENV_RPC_STRATEGY, STRATEGY_LATENCY)
+	maxLag := env.GetEnvInt(constants.ENV_RPC_MAX_LAG, 5)
+
+	p := &poolFetcher{
+		log:      log,
+		strategy: strategy,
+		maxLag:   maxLag,
+	}
+
+	for _, e := range endpoints {
+		p.upstreams = append(p.upstreams, &upstream{
+			fetcher:     newEthFetcher(log, e),
+			healthy:     true,
+			latestBlock: -1,
+		})
+	}
+
+	log.Printf("[INFO] RPC pool of %d endpoints using %s routing", len(endpoints), strategy)
+
+	go p.healthCheckLoop(ctx)
+
+	return p
+}
+
+func (p *poolFetcher) GetLatestBlock(ctx context.Context) (int, error) {
+	var latest int
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		latest, err = f.GetLatestBlock(ctx)
+		return err
+	})
+
+	return latest, err
+}
+
+func (p *poolFetcher) GetTaggedBlock(ctx context.Context, tag string) (int, error) {
+	var block int
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		block, err = f.GetTaggedBlock(ctx, tag)
+		return err
+	})
+
+	return block, err
+}
+
+func (p *poolFetcher) FetchBlock(ctx context.Context, blockNum int) (*BlockResult, error) {
+	var result *BlockResult
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		result, err = f.FetchBlock(ctx, blockNum)
+		return err
+	})
+
+	return result, err
+}
+
+func (p *poolFetcher) FetchBlocks(ctx context.Context, blockNums []int) ([]*BlockResult, error) {
+	var results []*BlockResult
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		results, err = f.FetchBlocks(ctx, blockNums)
+		return err
+	})
+
+	return results, err
+}
+
+func (p *poolFetcher) FetchReceipts(ctx context.Context, blockNum int, txHashes []string) (map[string]*Receipt, error) {
+	var receipts map[string]*Receipt
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		receipts, err = f.FetchReceipts(ctx, blockNum, txHashes)
+		return err
+	})
+
+	return receipts, err
+}
+
+func (p *poolFetcher) FetchLogs(ctx context.Context, filter LogFilter) ([]*Log, error) {
+	var logs []*Log
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		logs, err = f.FetchLogs(ctx, filter)
+		return err
+	})
+
+	return logs, err
+}
+
+func (p *poolFetcher) FetchTraces(ctx context.Context, blockNum int) ([]*InternalCall, error) {
+	var calls []*InternalCall
+	err := p.do(ctx, func(f *ethFetcher) (err error) {
+		calls, err = f.FetchTraces(ctx, blockNum)
+		return err
+	})
+
+	return calls, err
+}
+
+// SubscribeNewHeads subscribes on the first endpoint that supports it.
+func (p *poolFetcher) SubscribeNewHeads(ctx context.Context) (<-chan int, error) {
+	for _, u := range p.upstreams {
+		heads, err := u.fetcher.SubscribeNewHeads(ctx)
+		if err == nil {
+			return heads, nil
+		}
+	}
+
+	return nil, ErrSubscriptionsUnsupported
+}
+
+// Stats reports every endpoint of the pool.
+func (p *poolFetcher) Stats() []EndpointStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]EndpointStats, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		s := u.fetcher.stats.snapshot(u.fetcher.endpoint)
+		s.Healthy = u.healthy
+		s.LatestBlock = u.latestBlock
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+// Pin returns a context whose pool requests all go to the endpoint picked
+// by the first of them, so a block range and the hash checks made while
+// storing it see a single view of the chain. When that endpoint fails, the
+// requests fail over as usual and stick to the next one. Without a pool it
+// changes nothing.
+func Pin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pinKey{}, &pin{})
+}
+
+// do runs `fn` against the preferred endpoint and fails over to the next
+// one until it succeeds or every endpoint has been tried.
+func (p *poolFetcher) do(ctx context.Context, fn func(f *ethFetcher) error) error {
+	pinned, _ := ctx.Value(pinKey{}).(*pin)
+	tried := make(map[*upstream]bool)
+	lastErr := errNoUpstream
+
+	for range p.upstreams {
+		u := p.pick(tried, pinned)
+		if u == nil {
+			break
+		}
+		tried[u] = true
+
+		err := fn(u.fetcher)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return err
+		}
+
+		p.checkEjection(u, err)
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// pick chooses an untried endpoint according to the strategy, preferring
+// healthy ones, or the endpoint of `pinned` while it qualifies. If every
+// endpoint is ejected we still try them rather than stall ingestion.
+func (p *poolFetcher) pick(tried map[*upstream]bool, pinned *pin) *upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pinned != nil && pinned.upstream != nil && !tried[pinned.upstream] && pinned.upstream.healthy {
+		return pinned.upstream
+	}
+
+	var candidates []*upstream
+	for _, u := range p.upstreams {
+		if !tried[u] && u.healthy {
+			candidates = append(candidates, u)
+		}
+	}
+
+	if len(candidates) == 0 {
+		for _, u := range p.upstreams {
+			if !tried[u] {
+				candidates = append(candidates, u)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var picked *upstream
+	if p.strategy == STRATEGY_ROUND_ROBIN {
+		p.next++
+		picked = candidates[p.next%len(candidates)]
+	} else {
+		picked = pickOfTwo(candidates)
+	}
+
+	if pinned != nil {
+		pinned.upstream = picked
+	}
+
+	return picked
+}
+
+// pickOfTwo compares two random candidates and returns the faster one, so
+// the fastest endpoints get most of the load without taking all of it.
+func pickOfTwo(candidates []*upstream) *upstream {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := rand.IntN(len(candidates))
+	j := rand.IntN(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	if faster(candidates[j], candidates[i]) {
+		return candidates[j]
+	}
+
+	return candidates[i]
+}
+
+// faster reports whether `a` answers faster than `b`. An endpoint without
+// a successful request yet has no latency to compare and loses to one that
+// has; the health checks sample it soon enough.
+func faster(a, b *upstream) bool {
+	la, lb := a.fetcher.stats.averageLatency(), b.fetcher.stats.averageLatency()
+	if la == 0 {
+		return false
+	}
+
+	return lb == 0 || la < lb
+}
+
+// checkEjection ejects `u` once it has failed too many times in a row.
+func (p *poolFetcher) checkEjection(u *upstream, err error) {
+	if u.fetcher.stats.failuresInARow() < MAX_CONSECUTIVE_ERRORS {
+		return
+	}
+
+	// A throttled endpoint is alive; its rate limiter already holds it back.
+	if _, limited := IsRateLimited(err); limited {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if u.healthy {
+		u.healthy = false
+		p.log.Printf("[WARN] Ejected RPC endpoint %s: %v", u.fetcher.endpoint, err)
+	}
+}
+
+// healthCheckLoop periodically probes every endpoint until `ctx` is done.
+func (p *poolFetcher) healthCheckLoop(ctx context.Context) {
+	ticker := time.NewTicker(HEALTH_CHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		p.healthCheck(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// healthCheck asks every endpoint for its tip, then ejects the ones that
+// failed or lag more than maxLag blocks behind the highest tip, and restores
+// the others.
+func (p *poolFetcher) healthCheck(ctx context.Context) {
+	tips := make([]int, len(p.upstreams))
+	errs := make([]error, len(p.upstreams))
+
+	var wg sync.WaitGroup
+	for i, u := range p.upstreams {
+		wg.Add(1)
+		go func(i int, u *upstream) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, HEALTH_CHECK_TIMEOUT)
+			defer cancel()
+
+			tips[i], errs[i] = u.fetcher.GetLatestBlock(ctx)
+		}(i, u)
+	}
+	wg.Wait()
+
+	// Failures caused by shutting down say nothing about the endpoints
+	if ctx.Err() != nil {
+		return
+	}
+
+	highest := -1
+	for i := range p.upstreams {
+		if errs[i] == nil && tips[i] > highest {
+			highest = tips[i]
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, u := range p.upstreams {
+		healthy := errs[i] == nil && highest-tips[i] <= p.maxLag
+		if errs[i] == nil {
+			u.latestBlock = tips[i]
+		}
+
+		switch {
+		case u.healthy && !healthy && errs[i] != nil:
+			p.log.Printf("[WARN] Ejected RPC endpoint %s: %v", u.fetcher.endpoint, errs[i])
+		case u.healthy && !healthy:
+			p.log.Printf("[WARN] Ejected RPC endpoint %s: block %d is %d behind tip %d",
+				u.fetcher.endpoint, tips[i], highest-tips[i], highest)
+		case !u.healthy && healthy:
+			p.log.Printf("[INFO] Restored RPC endpoint %s at block %d", u.fetcher.endpoint, tips[i])
+		}
+
+		u.healthy = healthy
+	}
+}
@@ -0,0 +1,92 @@
+package rpcfetch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPickLatency(t *testing.T) {
+	fresh := &upstream{fetcher: &ethFetcher{endpoint: "fresh"}, healthy: true}
+	slow := &upstream{fetcher: &ethFetcher{endpoint: "slow"}, healthy: true}
+	fast := &upstream{fetcher: &ethFetcher{endpoint: "fast"}, healthy: true}
+
+	p := &poolFetcher{strategy: STRATEGY_LATENCY, upstreams: []*upstream{fresh, slow, fast}}
+
+	if got := p.pick(map[*upstream]bool{}, nil); got == nil {
+		t.Fatal("picked nothing without samples")
+	}
+
+	slow.fetcher.stats.record(200*time.Millisecond, nil)
+	fast.fetcher.stats.record(20*time.Millisecond, nil)
+
+	picks := make(map[*upstream]int)
+	for i := 0; i < 300; i++ {
+		picks[p.pick(map[*upstream]bool{}, nil)]++
+	}
+
+	// Of every pair, the unsampled endpoint always loses and fast always wins
+	if picks[fresh] != 0 {
+		t.Errorf("picked the unsampled endpoint %d times", picks[fresh])
+	}
+	if picks[fast] <= picks[slow] || picks[slow] == 0 {
+		t.Errorf("picked fast %d and slow %d times, want most but not all on fast", picks[fast], picks[slow])
+	}
+
+	if got := p.pick(map[*upstream]bool{fast: true}, nil); got != slow {
+		t.Errorf("picked %s, want slow over the unsampled endpoint", got.fetcher.endpoint)
+	}
+}
+
+func TestPickPinned(t *testing.T) {
+	var upstreams []*upstream
+	for _, e := range []string{"a", "b", "c"} {
+		u := &upstream{fetcher: &ethFetcher{endpoint: e}, healthy: true}
+		u.fetcher.stats.record(10*time.Millisecond, nil)
+		upstreams = append(upstreams, u)
+	}
+
+	p := &poolFetcher{strategy: STRATEGY_LATENCY, upstreams: upstreams}
+	pinned := &pin{}
+
+	first := p.pick(map[*upstream]bool{}, pinned)
+	for i := 0; i < 20; i++ {
+		if got := p.pick(map[*upstream]bool{}, pinned); got != first {
+			t.Fatalf("pinned requests went to %s and %s", first.fetcher.endpoint, got.fetcher.endpoint)
+		}
+	}
+
+	// Failing over moves the pin
+	next := p.pick(map[*upstream]bool{first: true}, pinned)
+	if next == first {
+		t.Fatal("failed over to the endpoint that failed")
+	}
+	if got := p.pick(map[*upstream]bool{}, pinned); got != next {
+		t.Errorf("after failing over picked %s, want %s", got.fetcher.endpoint, next.fetcher.endpoint)
+	}
+
+	// So does ejecting it
+	next.healthy = false
+	if got := p.pick(map[*upstream]bool{}, pinned); got == next {
+		t.Error("picked the ejected pinned endpoint")
+	}
+}
+
+func TestHealthCheckLoopStops(t *testing.T) {
+	p := &poolFetcher{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.healthCheckLoop(ctx)
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("health check loop still running after its context was cancelled")
+	}
+}
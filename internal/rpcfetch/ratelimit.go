@@ -0,0 +1,175 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// RPC_LIMIT_EXCEEDED is the JSON-RPC error code providers such as Infura
+	// return when a request is throttled.
+	RPC_LIMIT_EXCEEDED = -32005
+
+	// DEFAULT_RETRY_AFTER is the pause applied to a throttled endpoint that
+	// doesn't say how long to wait.
+	DEFAULT_RETRY_AFTER = time.Second
+)
+
+// RateLimitError is returned when the endpoint throttled a request.
+type RateLimitError struct {
+	StatusCode int
+	RetryAfter time.Duration // 0 if the endpoint didn't say
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited (status code %d), retry after %v", e.StatusCode, e.RetryAfter)
+	}
+
+	return fmt.Sprintf("rate limited (status code %d)", e.StatusCode)
+}
+
+// IsRateLimited reports whether `err` means the endpoint throttled the
+// request, and how long it asked to wait (0 if unknown).
+func IsRateLimited(err error) (time.Duration, bool) {
+	var limitErr *RateLimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.RetryAfter, true
+	}
+
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) && (rpcErr.Code == RPC_LIMIT_EXCEEDED || rpcErr.Code == http.StatusTooManyRequests) {
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// rateLimitError maps a throttling HTTP response onto a RateLimitError:
+// 429, or 503 with a Retry-After header. Returns nil for other responses.
+func rateLimitError(resp *http.Response) error {
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode == http.StatusServiceUnavailable && hasRetryAfter:
+	default:
+		return nil
+	}
+
+	return &RateLimitError{StatusCode: resp.StatusCode, RetryAfter: retryAfter}
+}
+
+// parseRetryAfter reads a Retry-After header, either delay-seconds or an
+// HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// rateLimiter is a token bucket of `rate` requests per second holding up to
+// `burst` tokens. A throttled endpoint also pauses it until its Retry-After.
+// A rate of 0 only honours the pauses.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	until  time.Time // no request before this
+}
+
+func newRateLimiter(rate, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+
+	return &rateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until `n` requests may be sent or `ctx` is done. A batch
+// larger than the bucket waits for a full bucket.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	for {
+		delay := l.reserve(float64(n))
+		if delay == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// reserve takes `n` tokens and returns 0, or returns how long to wait
+// before trying again.
+func (l *rateLimiter) reserve(n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.until) {
+		return l.until.Sub(now)
+	}
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if n > l.burst {
+		n = l.burst
+	}
+
+	if l.tokens >= n {
+		l.tokens -= n
+		return 0
+	}
+
+	return time.Duration((n - l.tokens) / l.rate * float64(time.Second))
+}
+
+// pause holds every request back for `d`, and drains the bucket so they
+// don't all resume at once.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.until) {
+		l.until = until
+	}
+	l.tokens = 0
+	l.last = l.until
+}
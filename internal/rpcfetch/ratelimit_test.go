@@ -0,0 +1,133 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     int
+		burst    int
+		requests []int           // sizes reserved in a row
+		waits    []time.Duration // expected delay of each, roughly
+	}{
+		{"within the burst", 10, 3, []int{1, 1, 1}, []time.Duration{0, 0, 0}},
+		{"past the burst", 10, 2, []int{1, 1, 1}, []time.Duration{0, 0, 100 * time.Millisecond}},
+		{"batch", 10, 5, []int{5, 2}, []time.Duration{0, 200 * time.Millisecond}},
+		{"batch larger than the burst", 10, 2, []int{2, 8}, []time.Duration{0, 200 * time.Millisecond}},
+		{"no rate", 0, 0, []int{100, 100}, []time.Duration{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRateLimiter(tt.rate, tt.burst)
+
+			for i, n := range tt.requests {
+				got := l.reserve(float64(n))
+				if want := tt.waits[i]; got < want-10*time.Millisecond || got > want+10*time.Millisecond {
+					t.Errorf("request %d: wait %v, want about %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterPause(t *testing.T) {
+	l := newRateLimiter(100, 10)
+
+	l.pause(time.Hour)
+	if got := l.reserve(1); got < 59*time.Minute {
+		t.Errorf("paused limiter let a request through in %v", got)
+	}
+
+	// A shorter pause doesn't cut a longer one short
+	l.pause(time.Millisecond)
+	if got := l.reserve(1); got < 59*time.Minute {
+		t.Errorf("a shorter pause shortened the wait to %v", got)
+	}
+
+	// Even without a rate, a pause holds requests back
+	unlimited := newRateLimiter(0, 0)
+	unlimited.pause(time.Hour)
+	if got := unlimited.reserve(1); got == 0 {
+		t.Error("paused limiter without a rate let a request through")
+	}
+}
+
+func TestRateLimiterPauseDrainsBucket(t *testing.T) {
+	l := newRateLimiter(100, 10)
+
+	l.pause(20 * time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	// About 1 token refilled since the pause ended, not the full burst
+	if got := l.reserve(10); got == 0 {
+		t.Error("the bucket was full right after the pause")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newRateLimiter(20, 1)
+
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("3 requests at 20/s with a burst of 1 took %v, want about 100ms", elapsed)
+	}
+
+	// A cancelled caller stops waiting
+	l.pause(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want the context error", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCallPausesOnThrottledResponse(t *testing.T) {
+	f := fetcherAnswering(t, `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`)
+
+	var output any
+	err := f.call(context.Background(), RPCRequest{JSONRPC: JSON_RPC_VERSION, Method: "eth_blockNumber", ID: 1}, &output)
+	if _, limited := IsRateLimited(err); !limited {
+		t.Fatalf("got %v, want a rate limit error", err)
+	}
+
+	if got := f.limiter.reserve(1); got < DEFAULT_RETRY_AFTER/2 {
+		t.Errorf("limiter lets the next request through in %v, want about %v", got, DEFAULT_RETRY_AFTER)
+	}
+}
@@ -1,51 +1,303 @@
 package rpcfetch
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/buildwithme/ethparser/pkg/constants"
 	"github.com/buildwithme/ethparser/pkg/env"
 	"github.com/buildwithme/ethparser/pkg/logger"
+	"github.com/buildwithme/ethparser/pkg/websocket"
 )
 
 type (
 	// BlockResult captures the outcome of processing a single block.
 	BlockResult struct {
 		BlockNumber  int
+		Hash         string
+		ParentHash   string
+		BaseFee      string // decimal, in wei; empty before London
+		Timestamp    int64  // unix seconds
+		Miner        string
+		GasUsed      string
+		GasLimit     string
 		Transactions []*BlockTransaction
-		Err          error
+		// InternalCalls are only filled when traces are fetched.
+		InternalCalls []*InternalCall
+		Err           error
 	}
 
-	// BlockTransaction is just a minimal representation before mapping to storage.Transaction.
+	// BlockTransaction is a decoded transaction before mapping to
+	// storage.Transaction. Quantities are decimal strings.
 	BlockTransaction struct {
-		Hash        string
-		From        string
-		To          string
-		BlockNumber int
-		Value       string
+		Hash             string
+		From             string
+		To               string
+		BlockNumber      int
+		TransactionIndex int
+		Value            string
+
+		Type                 string // one of TX_TYPE_*, or the raw hex type if unknown
+		ChainID              string
+		Nonce                string
+		Gas                  string
+		GasPrice             string
+		MaxFeePerGas         string // EIP-1559 and later
+		MaxPriorityFeePerGas string // EIP-1559 and later
+		MaxFeePerBlobGas     string // EIP-4844
+		Input                string
+		AccessList           []AccessTuple   // EIP-2930 and later
+		BlobVersionedHashes  []string        // EIP-4844
+		AuthorizationList    []Authorization // EIP-7702
+
+		// Receipt fields, only filled when receipts are fetched.
+		ExecutionStatus   string
+		GasUsed           string
+		EffectiveGasPrice string
+		ContractAddress   string
+		BlobGasUsed       string
+		BlobGasPrice      string
+	}
+
+	// RPCError is the error object of a JSON-RPC response.
+	RPCError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
 	}
 
 	// Fetcher is the interface for fetching blocks from an Ethereum node.
 	Fetcher interface {
 		// GetLatestBlock returns the latest block number from the endpoint.
 		GetLatestBlock(ctx context.Context) (int, error)
+		// GetTaggedBlock returns the block number for a tag such as "safe" or "finalized".
+		GetTaggedBlock(ctx context.Context, tag string) (int, error)
 		// FetchBlock returns a block result from the endpoint.
 		FetchBlock(ctx context.Context, blockNum int) (*BlockResult, error)
+		// FetchBlocks returns results for several blocks using one JSON-RPC batch.
+		// Per-block failures are reported in BlockResult.Err; the error is only
+		// set when the whole batch failed.
+		FetchBlocks(ctx context.Context, blockNums []int) ([]*BlockResult, error)
+		// FetchReceipts returns the receipts of `txHashes` in `blockNum`, keyed by hash.
+		FetchReceipts(ctx context.Context, blockNum int, txHashes []string) (map[string]*Receipt, error)
+		// FetchLogs returns the event logs matching `filter`.
+		FetchLogs(ctx context.Context, filter LogFilter) ([]*Log, error)
+		// FetchTraces returns the value-bearing internal calls of `blockNum`.
+		FetchTraces(ctx context.Context, blockNum int) ([]*InternalCall, error)
+		// Stats reports request counters and health for every upstream endpoint.
+		Stats() []EndpointStats
 	}
 
 	// ethFetcher is the implementation of Fetcher.
 	ethFetcher struct {
-		log      *logger.Logger
-		endpoint string
+		log       *logger.Logger
+		endpoint  string
+		mu        sync.Mutex
+		transport transport
+		limiter   *rateLimiter
+		lastID    atomic.Int64
+		stats     endpointStats
+		// noBlockReceipts remembers the endpoint lacks eth_getBlockReceipts.
+		noBlockReceipts atomic.Bool
+		// noDebugTrace remembers the endpoint lacks debug_traceBlockByNumber.
+		noDebugTrace atomic.Bool
 	}
 )
 
-// NewFetcher constructs an ethFetcher that fetches blocks from `endpoint`.
-func NewFetcher(log *logger.Logger) Fetcher {
-	endpoint := env.GetEnvString(constants.ENV_RPC_ENDPOINT, "https://cloudflare-eth.com")
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPC_METHOD_NOT_FOUND is the JSON-RPC error code of a method the endpoint
+// doesn't serve.
+const RPC_METHOD_NOT_FOUND = -32601
+
+// IsMethodUnsupported reports whether `err` means the endpoint doesn't serve
+// the method at all, as opposed to failing this one request.
+func IsMethodUnsupported(err error) bool {
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+
+	message := strings.ToLower(rpcErr.Message)
+	return rpcErr.Code == RPC_METHOD_NOT_FOUND ||
+		strings.Contains(message, "not supported") || strings.Contains(message, "unsupported")
+}
+
+// NewFetcher constructs a Fetcher for RPC_ENDPOINT. A comma-separated list
+// of endpoints yields a load-balancing pool with failover, health-checked
+// until `ctx` is done.
+func NewFetcher(ctx context.Context, log *logger.Logger) Fetcher {
+	var endpoints []string
+	for _, e := range strings.Split(env.GetEnvString(constants.ENV_RPC_ENDPOINT, "https://cloudflare-eth.com"), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			endpoints = append(endpoints, e)
+		}
+	}
+
+	if len(endpoints) > 1 {
+		return newPoolFetcher(ctx, log, endpoints)
+	}
+
+	return newEthFetcher(log, endpoints[0])
+}
+
+// newEthFetcher constructs an ethFetcher that fetches blocks from `endpoint`,
+// sending at most RPC_RATE_LIMIT requests per second when it is set.
+func newEthFetcher(log *logger.Logger, endpoint string) *ethFetcher {
+	rate := env.GetEnvInt(constants.ENV_RPC_RATE_LIMIT, 0)
+	burst := env.GetEnvInt(constants.ENV_RPC_RATE_BURST, rate)
 
 	return &ethFetcher{
-		log:      log,
-		endpoint: endpoint,
+		log:       log,
+		endpoint:  endpoint,
+		transport: newTransport(log, endpoint),
+		limiter:   newRateLimiter(rate, burst),
+	}
+}
+
+// newTransport picks the transport from the scheme of `endpoint`.
+func newTransport(log *logger.Logger, endpoint string) transport {
+	if isWebSocketURL(endpoint) {
+		return newWSTransport(log, endpoint)
+	}
+
+	return newHTTPTransport(endpoint)
+}
+
+// call sends `payload` over the current transport once the rate limiter
+// lets it through, and decodes the response into `output`. If a WebSocket
+// endpoint turns out to only speak HTTP, the fetcher switches to HTTP for
+// good. A JSON-RPC error response is returned as a *RPCError, so it counts
+// as a failure; in a batch, only a throttled request fails the whole call.
+// A throttled call pauses the limiter for the Retry-After the endpoint
+// asked for.
+func (p *ethFetcher) call(ctx context.Context, payload any, output any) error {
+	method, requests := methodOf(payload)
+	if err := p.limiter.wait(ctx, requests); err != nil {
+		return err
+	}
+
+	var raw json.RawMessage
+	started := time.Now()
+	err := p.currentTransport().call(ctx, payload, &raw)
+
+	var handshakeErr *websocket.HandshakeError
+	if errors.As(err, &handshakeErr) {
+		p.fallbackToHTTP(handshakeErr)
+		started = time.Now()
+		err = p.currentTransport().call(ctx, payload, &raw)
+	}
+
+	if err == nil {
+		err = responseError(raw)
+	}
+	if err == nil {
+		err = json.Unmarshal(raw, output)
+	}
+
+	// A cancelled caller says nothing about the endpoint's health.
+	if err == nil || ctx.Err() == nil {
+		p.stats.record(time.Since(started), err)
 	}
+
+	if retryAfter, ok := IsRateLimited(err); ok {
+		if retryAfter == 0 {
+			retryAfter = DEFAULT_RETRY_AFTER
+		}
+		p.limiter.pause(retryAfter)
+		p.log.Printf("[WARN] %s is rate limiting %s; pausing requests for %v", p.endpoint, method, retryAfter)
+	}
+
+	rpcRequests.Add(float64(requests), method, callStatus(err))
+	rpcDuration.Observe(time.Since(started).Seconds(), method)
+
+	return err
+}
+
+// responseError returns the error carried by a JSON-RPC response for the
+// whole call: the error of a single response, or the first throttling
+// error of a batch. Other errors of a batch are per request; the caller
+// handles them.
+func responseError(raw json.RawMessage) error {
+	type envelope struct {
+		Error *RPCError `json:"error"`
+	}
+
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []envelope
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			return err
+		}
+
+		for _, r := range batch {
+			if r.Error == nil {
+				continue
+			}
+			if _, limited := IsRateLimited(r.Error); limited {
+				return r.Error
+			}
+		}
+
+		return nil
+	}
+
+	var single envelope
+	if err := json.Unmarshal(trimmed, &single); err != nil {
+		return err
+	}
+	if single.Error != nil {
+		return single.Error
+	}
+
+	return nil
+}
+
+func (p *ethFetcher) currentTransport() transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.transport
+}
+
+// fallbackToHTTP replaces a WebSocket transport by HTTP on the same host.
+func (p *ethFetcher) fallbackToHTTP(cause error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.transport.(*wsTransport); !ok {
+		return
+	}
+
+	httpEndpoint := toHTTPURL(p.endpoint)
+	p.log.Printf("[WARN] %s does not accept WebSocket (%v); falling back to HTTP polling on %s",
+		p.endpoint, cause, httpEndpoint)
+
+	p.transport.close()
+	p.transport = newHTTPTransport(httpEndpoint)
+}
+
+// nextIDs reserves `n` consecutive JSON-RPC ids and returns the first one.
+func (p *ethFetcher) nextIDs(n int) int {
+	return int(p.lastID.Add(int64(n))) - n + 1
+}
+
+func isWebSocketURL(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "ws://") || strings.HasPrefix(endpoint, "wss://")
+}
+
+// toHTTPURL maps ws:// to http:// and wss:// to https://.
+func toHTTPURL(endpoint string) string {
+	if strings.HasPrefix(endpoint, "ws") {
+		return "http" + strings.TrimPrefix(endpoint, "ws")
+	}
+
+	return endpoint
 }
@@ -0,0 +1,123 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/buildwithme/ethparser/pkg/logger"
+)
+
+// fetcherAnswering returns an ethFetcher whose endpoint always answers `body`.
+func fetcherAnswering(t *testing.T, body string) *ethFetcher {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return newEthFetcher(logger.NewLogger(), server.URL)
+}
+
+func TestCallErrorResponses(t *testing.T) {
+	request := RPCRequest{JSONRPC: JSON_RPC_VERSION, Method: "eth_blockNumber", ID: 1}
+	batch := []RPCRequest{request, {JSONRPC: JSON_RPC_VERSION, Method: "eth_blockNumber", ID: 2}}
+
+	tests := []struct {
+		name    string
+		payload any
+		body    string
+		code    int // of the *RPCError returned, 0 for none
+		status  string
+	}{
+		{"result", request, `{"jsonrpc":"2.0","id":1,"result":"0x10"}`, 0, CALL_STATUS_OK},
+		{"error", request, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`, -32000, CALL_STATUS_ERROR},
+		{"limit exceeded", request, `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`, RPC_LIMIT_EXCEEDED, CALL_STATUS_LIMITED},
+		{"429 in the body", request, `{"jsonrpc":"2.0","id":1,"error":{"code":429,"message":"too many requests"}}`, http.StatusTooManyRequests, CALL_STATUS_LIMITED},
+		{"batch with a per-request error", batch, `[{"id":1,"result":"0x1"},{"id":2,"error":{"code":-32000,"message":"missing"}}]`, 0, CALL_STATUS_OK},
+		{"throttled batch", batch, `[{"id":1,"result":"0x1"},{"id":2,"error":{"code":-32005,"message":"limit exceeded"}}]`, RPC_LIMIT_EXCEEDED, CALL_STATUS_LIMITED},
+		{"batch answered by one error", batch, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch too large"}}`, -32600, CALL_STATUS_ERROR},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := fetcherAnswering(t, tt.body)
+
+			var output any
+			err := f.call(context.Background(), tt.payload, &output)
+
+			var rpcErr *RPCError
+			switch {
+			case tt.code == 0 && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case tt.code != 0 && (!errors.As(err, &rpcErr) || rpcErr.Code != tt.code):
+				t.Fatalf("got error %v, want RPC error %d", err, tt.code)
+			}
+
+			if got := callStatus(err); got != tt.status {
+				t.Errorf("call status = %s, want %s", got, tt.status)
+			}
+
+			failures := 0
+			if tt.code != 0 {
+				failures = 1
+			}
+			if got := f.stats.failuresInARow(); got != failures {
+				t.Errorf("recorded %d failures, want %d", got, failures)
+			}
+		})
+	}
+}
+
+func TestMethodFallback(t *testing.T) {
+	fallbacks := map[string]struct {
+		fetch    func(f *ethFetcher) error
+		fellBack func(f *ethFetcher) bool
+	}{
+		BLOCK_RECEIPTS_METHOD: {
+			fetch: func(f *ethFetcher) error {
+				_, err := f.FetchReceipts(context.Background(), 1, []string{"0xabc"})
+				return err
+			},
+			fellBack: func(f *ethFetcher) bool { return f.noBlockReceipts.Load() },
+		},
+		DEBUG_TRACE_BLOCK_METHOD: {
+			fetch: func(f *ethFetcher) error {
+				_, err := f.FetchTraces(context.Background(), 1)
+				return err
+			},
+			fellBack: func(f *ethFetcher) bool { return f.noDebugTrace.Load() },
+		},
+	}
+
+	responses := []struct {
+		name     string
+		body     string
+		fallBack bool
+	}{
+		{"method not found", `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"the method does not exist"}}`, true},
+		{"not supported", `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"method not supported"}}`, true},
+		{"limit exceeded", `{"jsonrpc":"2.0","id":1,"error":{"code":-32005,"message":"limit exceeded"}}`, false},
+		{"header not found", `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`, false},
+	}
+
+	for method, fallback := range fallbacks {
+		for _, tt := range responses {
+			t.Run(method+"/"+tt.name, func(t *testing.T) {
+				f := fetcherAnswering(t, tt.body)
+
+				err := fallback.fetch(f)
+				if got := fallback.fellBack(f); got != tt.fallBack {
+					t.Errorf("fell back = %v, want %v", got, tt.fallBack)
+				}
+				if !tt.fallBack && err == nil {
+					t.Error("the error was swallowed")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package rpcfetch
+
+import (
+	"sync"
+	"time"
+)
+
+// LATENCY_SMOOTHING is the weight of the newest sample in the latency EWMA.
+const LATENCY_SMOOTHING = 0.2
+
+// EndpointStats is a snapshot of how an upstream endpoint has been doing.
+type EndpointStats struct {
+	Endpoint          string  `json:"endpoint"`
+	Healthy           bool    `json:"healthy"`
+	LatestBlock       int     `json:"latestBlock"`
+	Requests          int64   `json:"requests"`
+	Errors            int64   `json:"errors"`
+	ConsecutiveErrors int     `json:"consecutiveErrors"`
+	LatencyMs         float64 `json:"latencyMs"`
+	LastError         string  `json:"lastError,omitempty"`
+}
+
+// endpointStats accumulates request outcomes for one endpoint.
+type endpointStats struct {
+	mu                sync.Mutex
+	requests          int64
+	errors            int64
+	consecutiveErrors int
+	latency           time.Duration
+	lastErr           error
+}
+
+// record adds the outcome of one request.
+func (s *endpointStats) record(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+
+	if err != nil {
+		s.errors++
+		s.consecutiveErrors++
+		s.lastErr = err
+		return
+	}
+
+	s.consecutiveErrors = 0
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = time.Duration(LATENCY_SMOOTHING*float64(latency) + (1-LATENCY_SMOOTHING)*float64(s.latency))
+	}
+}
+
+// averageLatency returns the smoothed latency of successful requests.
+func (s *endpointStats) averageLatency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency
+}
+
+// failuresInARow returns how many requests failed since the last success.
+func (s *endpointStats) failuresInARow() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.consecutiveErrors
+}
+
+// snapshot returns the counters for `endpoint`.
+func (s *endpointStats) snapshot(endpoint string) EndpointStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := EndpointStats{
+		Endpoint:          endpoint,
+		Healthy:           s.consecutiveErrors < MAX_CONSECUTIVE_ERRORS,
+		LatestBlock:       -1,
+		Requests:          s.requests,
+		Errors:            s.errors,
+		ConsecutiveErrors: s.consecutiveErrors,
+		LatencyMs:         float64(s.latency) / float64(time.Millisecond),
+	}
+
+	if s.lastErr != nil {
+		stats.LastError = s.lastErr.Error()
+	}
+
+	return stats
+}
+
+// Stats reports the counters of this single endpoint.
+func (p *ethFetcher) Stats() []EndpointStats {
+	return []EndpointStats{p.stats.snapshot(p.endpoint)}
+}
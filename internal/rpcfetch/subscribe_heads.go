@@ -0,0 +1,131 @@
+package rpcfetch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/buildwithme/ethparser/pkg/websocket"
+)
+
+const (
+	NEW_HEADS_SUBSCRIPTION = "newHeads"
+
+	// Reconnect backoff bounds for a dropped newHeads subscription.
+	MIN_RECONNECT_BACKOFF = 1 * time.Second
+	MAX_RECONNECT_BACKOFF = 30 * time.Second
+)
+
+// ErrSubscriptionsUnsupported is returned when the endpoint can't push heads.
+var ErrSubscriptionsUnsupported = errors.New("endpoint does not support subscriptions")
+
+type (
+	// HeadSubscriber is implemented by fetchers that can push new chain heads.
+	HeadSubscriber interface {
+		// SubscribeNewHeads streams the number of every new chain head. The
+		// channel survives reconnects and is closed once ctx is done or the
+		// endpoint turns out not to support subscriptions.
+		SubscribeNewHeads(ctx context.Context) (<-chan int, error)
+	}
+
+	// NewHeadNotification captures the block number of a newHeads notification.
+	NewHeadNotification struct {
+		Number string `json:"number"`
+	}
+)
+
+// SubscribeNewHeads subscribes to newHeads over the WebSocket transport.
+func (p *ethFetcher) SubscribeNewHeads(ctx context.Context) (<-chan int, error) {
+	ws, ok := p.currentTransport().(*wsTransport)
+	if !ok {
+		return nil, ErrSubscriptionsUnsupported
+	}
+
+	heads := make(chan int, 1)
+	go p.runHeadSubscription(ctx, ws, heads)
+
+	return heads, nil
+}
+
+// runHeadSubscription keeps a newHeads subscription alive, resubscribing
+// with exponential backoff whenever the connection drops.
+func (p *ethFetcher) runHeadSubscription(ctx context.Context, ws *wsTransport, heads chan int) {
+	defer close(heads)
+
+	backoff := MIN_RECONNECT_BACKOFF
+	for {
+		notifications, err := ws.subscribe(ctx, p.nextIDs(1), NEW_HEADS_SUBSCRIPTION)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			var rpcErr *RPCError
+			if errors.As(err, &rpcErr) {
+				p.log.Printf("[WARN] newHeads subscription rejected (%v); falling back to polling", err)
+				return
+			}
+
+			var handshakeErr *websocket.HandshakeError
+			if errors.As(err, &handshakeErr) {
+				p.fallbackToHTTP(handshakeErr)
+				return
+			}
+
+			p.log.Printf("[WARN] newHeads subscription failed: %v. Reconnecting in %v", err, backoff)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > MAX_RECONNECT_BACKOFF {
+				backoff = MAX_RECONNECT_BACKOFF
+			}
+			continue
+		}
+
+		p.log.Printf("[INFO] Subscribed to newHeads on %s", p.endpoint)
+		backoff = MIN_RECONNECT_BACKOFF
+
+		if !p.forwardHeads(ctx, notifications, heads) {
+			return
+		}
+
+		p.log.Printf("[WARN] newHeads subscription dropped; reconnecting")
+	}
+}
+
+// forwardHeads decodes notifications into `heads`, keeping only the newest
+// unread head. Returns false once ctx is done.
+func (p *ethFetcher) forwardHeads(ctx context.Context, notifications <-chan json.RawMessage, heads chan int) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case raw, ok := <-notifications:
+			if !ok {
+				return true
+			}
+
+			var head NewHeadNotification
+			if err := json.Unmarshal(raw, &head); err != nil {
+				continue
+			}
+
+			number, err := strconv.ParseInt(head.Number, 0, 64)
+			if err != nil {
+				continue
+			}
+
+			select {
+			case <-heads:
+			default:
+			}
+			heads <- int(number)
+		}
+	}
+}
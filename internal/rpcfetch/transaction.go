@@ -0,0 +1,106 @@
+package rpcfetch
+
+import (
+	"strconv"
+)
+
+// Transaction envelope types, by the EIP that introduced them.
+const (
+	TX_TYPE_LEGACY      = "legacy"      // pre EIP-2718
+	TX_TYPE_ACCESS_LIST = "access-list" // EIP-2930
+	TX_TYPE_DYNAMIC_FEE = "dynamic-fee" // EIP-1559
+	TX_TYPE_BLOB        = "blob"        // EIP-4844
+	TX_TYPE_SET_CODE    = "set-code"    // EIP-7702
+)
+
+// txTypeNames maps the hex `type` of a transaction to its name.
+var txTypeNames = map[string]string{
+	"0x0": TX_TYPE_LEGACY,
+	"0x1": TX_TYPE_ACCESS_LIST,
+	"0x2": TX_TYPE_DYNAMIC_FEE,
+	"0x3": TX_TYPE_BLOB,
+	"0x4": TX_TYPE_SET_CODE,
+}
+
+type (
+	// AccessTuple is one entry of an EIP-2930 access list.
+	AccessTuple struct {
+		Address     string   `json:"address"`
+		StorageKeys []string `json:"storageKeys"`
+	}
+
+	// Authorization is one entry of an EIP-7702 authorization list.
+	Authorization struct {
+		ChainID string `json:"chainId"`
+		Address string `json:"address"`
+		Nonce   string `json:"nonce"`
+	}
+
+	// TransactionResultResponse captures a transaction as returned by the
+	// node. Fields that don't apply to its type are left empty.
+	TransactionResultResponse struct {
+		Type                 string          `json:"type"`
+		ChainID              string          `json:"chainId"`
+		Hash                 string          `json:"hash"`
+		BlockNumber          string          `json:"blockNumber"`
+		TransactionIndex     string          `json:"transactionIndex"`
+		From                 string          `json:"from"`
+		To                   string          `json:"to"`
+		Value                string          `json:"value"`
+		Nonce                string          `json:"nonce"`
+		Gas                  string          `json:"gas"`
+		GasPrice             string          `json:"gasPrice"`
+		MaxFeePerGas         string          `json:"maxFeePerGas"`
+		MaxPriorityFeePerGas string          `json:"maxPriorityFeePerGas"`
+		MaxFeePerBlobGas     string          `json:"maxFeePerBlobGas"`
+		Input                string          `json:"input"`
+		AccessList           []AccessTuple   `json:"accessList"`
+		BlobVersionedHashes  []string        `json:"blobVersionedHashes"`
+		AuthorizationList    []Authorization `json:"authorizationList"`
+	}
+)
+
+// toBlockTransaction decodes the hex quantities of a node transaction.
+func (p *ethFetcher) toBlockTransaction(raw TransactionResultResponse) *BlockTransaction {
+	blockNumber, _ := strconv.ParseInt(raw.BlockNumber, 0, 64)
+	txIndex, _ := strconv.ParseInt(raw.TransactionIndex, 0, 64)
+
+	// Pre EIP-2718 nodes don't report a type at all.
+	txType := TX_TYPE_LEGACY
+	if raw.Type != "" {
+		txType = raw.Type
+		if name, ok := txTypeNames[raw.Type]; ok {
+			txType = name
+		}
+	}
+
+	var authorizations []Authorization
+	for _, a := range raw.AuthorizationList {
+		authorizations = append(authorizations, Authorization{
+			ChainID: p.getBigIntValue(a.ChainID),
+			Address: a.Address,
+			Nonce:   p.getBigIntValue(a.Nonce),
+		})
+	}
+
+	return &BlockTransaction{
+		BlockNumber:          int(blockNumber),
+		TransactionIndex:     int(txIndex),
+		Hash:                 raw.Hash,
+		From:                 raw.From,
+		To:                   raw.To,
+		Value:                p.getBigIntValue(raw.Value),
+		Type:                 txType,
+		ChainID:              p.getBigIntValue(raw.ChainID),
+		Nonce:                p.getBigIntValue(raw.Nonce),
+		Gas:                  p.getBigIntValue(raw.Gas),
+		GasPrice:             p.getBigIntValue(raw.GasPrice),
+		MaxFeePerGas:         p.getBigIntValue(raw.MaxFeePerGas),
+		MaxPriorityFeePerGas: p.getBigIntValue(raw.MaxPriorityFeePerGas),
+		MaxFeePerBlobGas:     p.getBigIntValue(raw.MaxFeePerBlobGas),
+		Input:                raw.Input,
+		AccessList:           raw.AccessList,
+		BlobVersionedHashes:  raw.BlobVersionedHashes,
+		AuthorizationList:    authorizations,
+	}
+}
@@ -0,0 +1,80 @@
+package rpcfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// transport sends a JSON-RPC payload (a single request or a batch) and
+// decodes the response into `output`.
+type transport interface {
+	call(ctx context.Context, payload any, output any) error
+	close() error
+}
+
+// httpTransport posts every call to the endpoint.
+type httpTransport struct {
+	endpoint string
+}
+
+func newHTTPTransport(endpoint string) *httpTransport {
+	return &httpTransport{endpoint: endpoint}
+}
+
+func (t *httpTransport) call(ctx context.Context, payload any, output any) error {
+	resp, err := t.postRequest(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	return t.decode(resp, output)
+}
+
+func (t *httpTransport) close() error {
+	return nil
+}
+
+// postRequest sends the JSON-encoded payload to the endpoint.
+func (t *httpTransport) postRequest(ctx context.Context, payload any) (*http.Response, error) {
+	reqBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// decode checks the status code and decodes the response body into `output`.
+// Throttling responses yield a *RateLimitError.
+func (t *httpTransport) decode(resp *http.Response, output any) error {
+	defer resp.Body.Close()
+
+	if err := rateLimitError(resp); err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,378 @@
+package rpcfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/buildwithme/ethparser/pkg/logger"
+	"github.com/buildwithme/ethparser/pkg/websocket"
+)
+
+const (
+	SUBSCRIBE_METHOD          = "eth_subscribe"
+	SUBSCRIPTION_NOTIFICATION = "eth_subscription"
+
+	// SUBSCRIPTION_BUFFER is how many notifications a subscriber may lag by.
+	SUBSCRIPTION_BUFFER = 16
+
+	// MAX_EARLY_SUBSCRIPTIONS bounds how many unknown subscription ids have
+	// their notifications held back, waiting for their eth_subscribe reply
+	// to be processed.
+	MAX_EARLY_SUBSCRIPTIONS = 8
+
+	// WS_PING_INTERVAL is how often an idle connection is pinged.
+	WS_PING_INTERVAL = 15 * time.Second
+
+	// WS_READ_TIMEOUT is how long the connection may stay silent, pongs
+	// included, before it is considered dead and dropped.
+	WS_READ_TIMEOUT = 3 * WS_PING_INTERVAL
+)
+
+// errConnectionLost is returned to calls still waiting when the socket drops.
+var errConnectionLost = errors.New("websocket connection lost")
+
+type (
+	// wsEnvelope is the subset of a JSON-RPC message needed to route it.
+	wsEnvelope struct {
+		ID     *int   `json:"id"`
+		Method string `json:"method"`
+		Params struct {
+			Subscription string          `json:"subscription"`
+			Result       json.RawMessage `json:"result"`
+		} `json:"params"`
+	}
+
+	// SubscribeResponse captures the subscription id returned by eth_subscribe.
+	SubscribeResponse struct {
+		Result string    `json:"result"`
+		Error  *RPCError `json:"error"`
+	}
+)
+
+// wsTransport multiplexes JSON-RPC calls and subscriptions over a single
+// WebSocket. The connection is (re)dialed lazily by the next call after it drops.
+type wsTransport struct {
+	log     *logger.Logger
+	url     string
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	dialing *wsDial // in-flight dial shared by concurrent callers
+	pending map[int]chan []byte
+	subs    map[string]chan json.RawMessage
+	// early holds the notifications of subscription ids not registered yet:
+	// the server may notify before subscribe has handled its reply.
+	early      map[string][]json.RawMessage
+	earlyOrder []string
+}
+
+// wsDial is the outcome of a dial, ready once done is closed.
+type wsDial struct {
+	done chan struct{}
+	conn *websocket.Conn
+	err  error
+}
+
+func newWSTransport(log *logger.Logger, url string) *wsTransport {
+	return &wsTransport{
+		log:     log,
+		url:     url,
+		pending: make(map[int]chan []byte),
+		subs:    make(map[string]chan json.RawMessage),
+		early:   make(map[string][]json.RawMessage),
+	}
+}
+
+// call sends `payload` and waits for the response carrying its id(s).
+func (t *wsTransport) call(ctx context.Context, payload any, output any) error {
+	ids := requestIDs(payload)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	conn, err := t.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	// A batch answer is routed by whichever of its ids comes first,
+	// so every id of the batch points at the same channel.
+	reply := make(chan []byte, 1)
+	t.mu.Lock()
+	for _, id := range ids {
+		t.pending[id] = reply
+	}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		for _, id := range ids {
+			delete(t.pending, id)
+		}
+		t.mu.Unlock()
+	}()
+
+	if err := conn.WriteMessage(data); err != nil {
+		t.drop(conn)
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case msg, ok := <-reply:
+		if !ok {
+			return errConnectionLost
+		}
+		return json.Unmarshal(msg, output)
+	}
+}
+
+// subscribe starts an eth_subscribe stream. The returned channel is closed
+// when the connection drops.
+func (t *wsTransport) subscribe(ctx context.Context, id int, params ...any) (<-chan json.RawMessage, error) {
+	payload := RPCRequest{
+		JSONRPC: JSON_RPC_VERSION,
+		Method:  SUBSCRIBE_METHOD,
+		Params:  params,
+		ID:      id,
+	}
+
+	var response SubscribeResponse
+	if err := t.call(ctx, payload, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Error != nil {
+		return nil, response.Error
+	}
+
+	notifications := make(chan json.RawMessage, SUBSCRIPTION_BUFFER)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn == nil {
+		return nil, errConnectionLost
+	}
+
+	// Hand over what was pushed before we got here
+	for _, n := range t.early[response.Result] {
+		notifications <- n
+	}
+	delete(t.early, response.Result)
+
+	t.subs[response.Result] = notifications
+
+	return notifications, nil
+}
+
+func (t *wsTransport) close() error {
+	t.mu.Lock()
+	conn := t.conn
+	t.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+
+	t.drop(conn)
+
+	return nil
+}
+
+// connect returns the live connection, dialing a new one if needed. Only
+// one dial runs at a time, outside t.mu; concurrent callers share its
+// outcome.
+func (t *wsTransport) connect(ctx context.Context) (*websocket.Conn, error) {
+	t.mu.Lock()
+	if t.conn != nil {
+		conn := t.conn
+		t.mu.Unlock()
+		return conn, nil
+	}
+
+	if d := t.dialing; d != nil {
+		t.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-d.done:
+			return d.conn, d.err
+		}
+	}
+
+	d := &wsDial{done: make(chan struct{})}
+	t.dialing = d
+	t.mu.Unlock()
+
+	d.conn, d.err = websocket.Dial(ctx, t.url)
+
+	t.mu.Lock()
+	t.dialing = nil
+	if d.err == nil {
+		t.conn = d.conn
+		go t.readLoop(d.conn)
+	}
+	t.mu.Unlock()
+
+	close(d.done)
+
+	return d.conn, d.err
+}
+
+// readLoop routes responses to waiting calls and notifications to
+// subscribers. A connection silent for WS_READ_TIMEOUT, despite the pings
+// of keepAlive, is dropped.
+func (t *wsTransport) readLoop(conn *websocket.Conn) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go t.keepAlive(conn, stop)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(WS_READ_TIMEOUT))
+
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			t.log.Printf("[WARN] WebSocket read failed: %v", err)
+			t.drop(conn)
+			return
+		}
+
+		t.route(msg)
+	}
+}
+
+// keepAlive pings `conn` every WS_PING_INTERVAL until `stop` is closed, so
+// a healthy but idle connection still answers before WS_READ_TIMEOUT.
+func (t *wsTransport) keepAlive(conn *websocket.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(WS_PING_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := conn.Ping(); err != nil {
+				t.drop(conn)
+				return
+			}
+		}
+	}
+}
+
+// route delivers a single incoming message.
+func (t *wsTransport) route(msg []byte) {
+	trimmed := bytes.TrimSpace(msg)
+
+	var env wsEnvelope
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var batch []wsEnvelope
+		if err := json.Unmarshal(trimmed, &batch); err != nil || len(batch) == 0 {
+			return
+		}
+		env = batch[0]
+	} else if err := json.Unmarshal(trimmed, &env); err != nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if env.Method == SUBSCRIPTION_NOTIFICATION {
+		ch, ok := t.subs[env.Params.Subscription]
+		if !ok {
+			t.holdEarly(env.Params.Subscription, env.Params.Result)
+			return
+		}
+
+		select {
+		case ch <- env.Params.Result:
+		default:
+			// Slow subscriber: drop, the next notification supersedes it.
+		}
+		return
+	}
+
+	if env.ID == nil {
+		return
+	}
+
+	if ch, ok := t.pending[*env.ID]; ok {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+// holdEarly keeps a notification of a subscription id that isn't
+// registered yet, for at most MAX_EARLY_SUBSCRIPTIONS ids (the oldest is
+// forgotten first) and SUBSCRIPTION_BUFFER notifications each.
+// Caller must hold t.mu.
+func (t *wsTransport) holdEarly(id string, result json.RawMessage) {
+	if _, ok := t.early[id]; !ok {
+		if len(t.earlyOrder) >= MAX_EARLY_SUBSCRIPTIONS {
+			delete(t.early, t.earlyOrder[0])
+			t.earlyOrder = t.earlyOrder[1:]
+		}
+		t.earlyOrder = append(t.earlyOrder, id)
+	}
+
+	if len(t.early[id]) < SUBSCRIPTION_BUFFER {
+		t.early[id] = append(t.early[id], result)
+	}
+}
+
+// drop closes `conn` and fails everything that depended on it.
+func (t *wsTransport) drop(conn *websocket.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conn != conn {
+		return
+	}
+
+	conn.Close()
+	t.conn = nil
+
+	closed := make(map[chan []byte]bool)
+	for id, ch := range t.pending {
+		if !closed[ch] {
+			close(ch)
+			closed[ch] = true
+		}
+		delete(t.pending, id)
+	}
+
+	for id, ch := range t.subs {
+		close(ch)
+		delete(t.subs, id)
+	}
+
+	t.early = make(map[string][]json.RawMessage)
+	t.earlyOrder = nil
+}
+
+// requestIDs lists the ids carried by a single request or a batch.
+func requestIDs(payload any) []int {
+	switch p := payload.(type) {
+	case RPCRequest:
+		return []int{p.ID}
+	case []RPCRequest:
+		ids := make([]int, 0, len(p))
+		for _, r := range p {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package rpcfetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buildwithme/ethparser/pkg/logger"
+)
+
+func TestRouteHoldsEarlyNotifications(t *testing.T) {
+	tr := newWSTransport(nil, "")
+	notify := func(id string, n int) {
+		tr.route([]byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"eth_subscription","params":{"subscription":"%s","result":%d}}`, id, n)))
+	}
+
+	// Pushed before subscribe registered "0x1"
+	notify("0x1", 1)
+	notify("0x1", 2)
+	if got := len(tr.early["0x1"]); got != 2 {
+		t.Fatalf("held %d notifications, want 2", got)
+	}
+
+	for i := 0; i < SUBSCRIPTION_BUFFER+5; i++ {
+		notify("0x2", i)
+	}
+	if got := len(tr.early["0x2"]); got != SUBSCRIPTION_BUFFER {
+		t.Errorf("held %d notifications, want at most %d", got, SUBSCRIPTION_BUFFER)
+	}
+
+	// Unclaimed ids are forgotten oldest first
+	for i := 0; i < MAX_EARLY_SUBSCRIPTIONS; i++ {
+		notify(fmt.Sprintf("0xf%d", i), i)
+	}
+	if _, ok := tr.early["0x1"]; ok {
+		t.Error("the oldest unclaimed subscription was not evicted")
+	}
+	if len(tr.early) != MAX_EARLY_SUBSCRIPTIONS {
+		t.Errorf("holding %d subscriptions, want %d", len(tr.early), MAX_EARLY_SUBSCRIPTIONS)
+	}
+}
+
+func TestConnectDialsOutsideTheLock(t *testing.T) {
+	// An endpoint accepting TCP but never answering the upgrade
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	accepted := make(chan net.Conn, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	defer func() {
+		for len(accepted) > 0 {
+			(<-accepted).Close()
+		}
+	}()
+
+	tr := newWSTransport(logger.NewLogger(), "ws://"+ln.Addr().String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := tr.connect(ctx)
+		dialed <- err
+	}()
+
+	for {
+		tr.mu.Lock()
+		dialing := tr.dialing != nil
+		tr.mu.Unlock()
+		if dialing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Routing and closing don't wait for the dial
+	done := make(chan struct{})
+	go func() {
+		tr.route([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+		tr.close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("the transport is blocked behind the dial")
+	}
+
+	// A concurrent caller shares the dial, bounded by its own context
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	if _, err := tr.connect(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second caller got %v, want its own deadline", err)
+	}
+
+	if err := <-dialed; err == nil {
+		t.Error("dial succeeded against an endpoint that never answered")
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// RECENT_HEADERS is how many of the latest stored blocks keep their header
+// whether or not they hold subscribed TXs, so reorgs can be detected
+// across restarts.
+const RECENT_HEADERS = 64
+
+type (
+	// BlockHeader is the metadata of a block holding stored TXs. It is kept
+	// once per block and joined onto its TXs when they are read.
+	BlockHeader struct {
+		Number     int
+		Hash       string
+		ParentHash string
+		Timestamp  int64 // unix seconds
+		Miner      string
+		GasUsed    string
+		GasLimit   string
+		BaseFee    string `json:",omitempty"` // decimal wei; empty before London
+	}
+
+	// TxFilter narrows the TXs returned by GetTransactions. Zero values
+	// don't filter.
+	TxFilter struct {
+		FromBlock int      // inclusive
+		ToBlock   int      // inclusive
+		FromTime  int64    // unix seconds, inclusive
+		ToTime    int64    // unix seconds, inclusive
+		Direction string   // DIRECTION_IN or DIRECTION_OUT, relative to the queried address
+		MinValue  *big.Int // in wei
+	}
+)
+
+func (m *memoryStorage) GetRecentHeaders() []BlockHeader {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedRecent()
+}
+
+// sortedRecent returns the recent headers in ascending order.
+// Caller must hold m.mu (read or write).
+func (m *memoryStorage) sortedRecent() []BlockHeader {
+	headers := make([]BlockHeader, 0, len(m.recent))
+	for _, h := range m.recent {
+		headers = append(headers, h)
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Number < headers[j].Number })
+
+	return headers
+}
+
+// keepRecent records `header` among the recent headers and forgets those
+// RECENT_HEADERS or more blocks behind it. Headers without a hash, from
+// logs written before they were kept, are skipped. Caller must hold m.mu.
+func (m *memoryStorage) keepRecent(header BlockHeader) {
+	if header.Hash == "" {
+		return
+	}
+
+	m.recent[header.Number] = header
+	for block := range m.recent {
+		if block <= header.Number-RECENT_HEADERS {
+			delete(m.recent, block)
+		}
+	}
+}
+
+// matches reports whether `tx` of address `addr`, mined in `header`,
+// passes the filter.
+func (f TxFilter) matches(addr string, tx Transaction, header *BlockHeader) bool {
+	if f.FromBlock != 0 && tx.BlockNumber < f.FromBlock {
+		return false
+	}
+
+	if f.ToBlock != 0 && tx.BlockNumber > f.ToBlock {
+		return false
+	}
+
+	switch f.Direction {
+	case DIRECTION_OUT:
+		if !strings.EqualFold(tx.From, addr) {
+			return false
+		}
+	case DIRECTION_IN:
+		if !strings.EqualFold(tx.To, addr) && !strings.EqualFold(tx.ContractAddress, addr) {
+			return false
+		}
+	}
+
+	if !matchesValue(tx.Value, f.MinValue) {
+		return false
+	}
+
+	return f.matchesTime(header)
+}
+
+// matchesTime reports whether a TX mined in `header` is in the time range.
+// TXs without a known header can't be placed in time and only pass when no
+// time range is set.
+func (f TxFilter) matchesTime(header *BlockHeader) bool {
+	if f.FromTime == 0 && f.ToTime == 0 {
+		return true
+	}
+
+	if header == nil {
+		return false
+	}
+
+	if f.FromTime != 0 && header.Timestamp < f.FromTime {
+		return false
+	}
+
+	if f.ToTime != 0 && header.Timestamp > f.ToTime {
+		return false
+	}
+
+	return true
+}
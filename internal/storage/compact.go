@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// COMPACT_MIN_SIZE is the log size below which it's never compacted at
+// runtime; replaying a small log is cheap.
+const COMPACT_MIN_SIZE = 64 << 20
+
+// maybeCompact compacts the log once it has doubled since the last
+// compaction. A failure is only logged: the log itself is still intact.
+// Caller must hold f.mu.
+func (f *fileStorage) maybeCompact() {
+	if f.size < COMPACT_MIN_SIZE || f.size < 2*f.compactedSize {
+		return
+	}
+
+	if err := f.compact(); err != nil {
+		log.Printf("[WARN] Storage log compaction failed: %v", err)
+	}
+}
+
+// compact rewrites the log as a snapshot of the index, then swaps it in
+// for appending. Caller must hold f.mu (or be opening the storage).
+func (f *fileStorage) compact() error {
+	tmp := f.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("compact storage log: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	f.index.mu.RLock()
+	records := f.index.snapshot()
+	f.index.mu.RUnlock()
+
+	writer := bufio.NewWriter(file)
+	var size int64
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			file.Close()
+			return fmt.Errorf("compact storage log: %w", err)
+		}
+
+		n, _ := writer.Write(append(data, '\n'))
+		size += int64(n)
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("compact storage log: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("compact storage log: %w", err)
+	}
+
+	if err := os.Rename(tmp, f.path); err != nil {
+		file.Close()
+		return fmt.Errorf("compact storage log: %w", err)
+	}
+	syncDir(filepath.Dir(f.path))
+
+	f.file.Close()
+	f.file = file
+	f.size = size
+	f.compactedSize = size
+
+	return nil
+}
+
+// snapshot returns the records that rebuild the index as it is now.
+// Caller must hold m.mu (read or write).
+func (m *memoryStorage) snapshot() []logRecord {
+	var records []logRecord
+
+	subs := make([]Subscription, 0, len(m.subscribed))
+	for _, s := range m.subscribed {
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].Address < subs[j].Address })
+
+	for i := range subs {
+		records = append(records, logRecord{Op: RECORD_SUBSCRIBE, Address: subs[i].Address, Subscription: &subs[i]})
+	}
+
+	headers := make([]BlockHeader, 0, len(m.headers))
+	for _, h := range m.headers {
+		headers = append(headers, h)
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Number < headers[j].Number })
+
+	if len(headers) > 0 {
+		records = append(records, logRecord{Op: RECORD_HEADERS, Headers: headers})
+	}
+
+	if len(m.recent) > 0 {
+		records = append(records, logRecord{Op: RECORD_RECENT_HEADERS, Headers: m.sortedRecent()})
+	}
+
+	for _, a := range sortedKeys(m.transactions) {
+		if len(m.transactions[a]) > 0 {
+			records = append(records, logRecord{Op: RECORD_ADDRESS_TXS, Address: a, Transactions: m.transactions[a]})
+		}
+	}
+
+	for _, a := range sortedKeys(m.tokenTransfers) {
+		if len(m.tokenTransfers[a]) > 0 {
+			records = append(records, logRecord{Op: RECORD_ADDRESS_TRANSFERS, Address: a, Transfers: m.tokenTransfers[a]})
+		}
+	}
+
+	if len(m.gaps) > 0 {
+		records = append(records, logRecord{Op: RECORD_GAPS, Blocks: m.sortedGaps()})
+	}
+
+	if m.lastBlock >= 0 {
+		records = append(records, logRecord{Op: RECORD_BLOCK, Block: m.lastBlock})
+	}
+
+	return records
+}
+
+// sortedKeys returns the keys of `m` in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// syncDir fsyncs a directory so a rename in it survives a crash.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	d.Sync()
+}
@@ -0,0 +1,435 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RECORD_SUBSCRIBE       = "subscribe"
+	RECORD_UNSUBSCRIBE     = "unsubscribe"
+	RECORD_BLOCK           = "block"
+	RECORD_ROLLBACK        = "rollback"
+	RECORD_TOKENS          = "tokens" // only in logs written before blocks carried their transfers
+	RECORD_BACKFILL        = "backfill"
+	RECORD_BACKFILL_TOKENS = "backfill-tokens" // only in logs written before backfills carried their transfers
+	RECORD_GAPS            = "gaps"
+	RECORD_GAP_FILLED      = "gap-filled"
+
+	// Snapshot records, only written by compaction.
+	RECORD_HEADERS           = "headers"
+	RECORD_RECENT_HEADERS    = "recent-headers"
+	RECORD_ADDRESS_TXS       = "address-txs"
+	RECORD_ADDRESS_TRANSFERS = "address-transfers"
+)
+
+// logRecord is a single line of the append-only log.
+type logRecord struct {
+	Op           string          `json:"op"`
+	Address      string          `json:"address,omitempty"`
+	Subscription *Subscription   `json:"subscription,omitempty"`
+	Purge        bool            `json:"purge,omitempty"`
+	Block        int             `json:"block"`
+	Header       *BlockHeader    `json:"header,omitempty"`
+	Transactions []Transaction   `json:"txs,omitempty"`
+	Transfers    []TokenTransfer `json:"transfers,omitempty"`
+	Blocks       []int           `json:"blocks,omitempty"`
+	Headers      []BlockHeader   `json:"headers,omitempty"`
+}
+
+// fileStorage persists every mutation to an append-only log and keeps
+// an in-memory index rebuilt from that log on startup. The log is compacted
+// to a snapshot of the index when opened and whenever it has doubled since.
+type fileStorage struct {
+	mu            sync.Mutex
+	path          string
+	file          *os.File
+	size          int64
+	compactedSize int64
+	index         *memoryStorage
+}
+
+// NewFileStorage opens (or creates) the log at `path`, replays it and
+// compacts it. A torn trailing record left by a crash is truncated away, so
+// the index always reflects whole blocks only; a corrupt record followed by
+// valid ones is an error, so no data is dropped silently.
+func NewFileStorage(path string) (Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create storage dir: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open storage log: %w", err)
+	}
+
+	fs := &fileStorage{
+		path:  path,
+		file:  file,
+		index: newMemoryStorage(),
+	}
+
+	if err := fs.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := fs.compact(); err != nil {
+		fs.file.Close()
+		return nil, err
+	}
+
+	return fs, nil
+}
+
+// replay applies every complete record to the index and truncates a torn
+// record at the end of the log. A corrupt record anywhere else fails the
+// replay rather than dropping the records after it.
+func (f *fileStorage) replay() error {
+	reader := bufio.NewReader(f.file)
+
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("[WARN] Discarding incomplete storage record at offset %d", offset)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read storage log: %w", err)
+		}
+
+		var rec logRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			rest, readErr := io.ReadAll(reader)
+			if readErr != nil {
+				return fmt.Errorf("read storage log: %w", readErr)
+			}
+			if len(bytes.TrimSpace(rest)) > 0 {
+				return fmt.Errorf("corrupt storage record at offset %d, followed by %d bytes of records: %w",
+					offset, len(rest), err)
+			}
+
+			log.Printf("[WARN] Discarding corrupt trailing storage record at offset %d: %v", offset, err)
+			break
+		}
+
+		f.apply(rec)
+		offset += int64(len(line))
+	}
+
+	if err := f.file.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate storage log: %w", err)
+	}
+
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek storage log: %w", err)
+	}
+	f.size = offset
+
+	return nil
+}
+
+// apply mutates the in-memory index. Caller must hold f.mu (or be replaying).
+func (f *fileStorage) apply(rec logRecord) {
+	f.index.mu.Lock()
+	defer f.index.mu.Unlock()
+
+	switch rec.Op {
+	case RECORD_SUBSCRIBE:
+		sub := Subscription{Address: rec.Address}
+		if rec.Subscription != nil {
+			sub = *rec.Subscription
+		}
+		f.index.subscribe(sub)
+	case RECORD_UNSUBSCRIBE:
+		f.index.unsubscribe(rec.Address, rec.Purge)
+	case RECORD_BLOCK:
+		header := BlockHeader{Number: rec.Block}
+		if rec.Header != nil {
+			header = *rec.Header
+		}
+		f.index.storeBlock(header, rec.Transactions, rec.Transfers)
+	case RECORD_BACKFILL:
+		f.index.storeBackfill(rec.Address, *rec.Header, rec.Transactions, rec.Transfers)
+	case RECORD_ROLLBACK:
+		f.index.rollback(rec.Block)
+	case RECORD_TOKENS:
+		f.index.storeTokenTransfers(rec.Transfers)
+	case RECORD_BACKFILL_TOKENS:
+		f.index.storeBackfillTokenTransfers(rec.Address, rec.Transfers)
+	case RECORD_GAPS:
+		f.index.addGaps(rec.Blocks)
+	case RECORD_GAP_FILLED:
+		delete(f.index.gaps, rec.Block)
+	case RECORD_HEADERS:
+		for _, h := range rec.Headers {
+			f.index.headers[h.Number] = h
+		}
+	case RECORD_RECENT_HEADERS:
+		for _, h := range rec.Headers {
+			f.index.keepRecent(h)
+		}
+	case RECORD_ADDRESS_TXS:
+		// Snapshots written before TXs were kept sorted by key are
+		// only sorted by block.
+		f.index.transactions[rec.Address] = insertByKey(nil, rec.Transactions...)
+		for _, tx := range rec.Transactions {
+			f.index.txKeys[addressTxKey(rec.Address, tx)] = true
+		}
+	case RECORD_ADDRESS_TRANSFERS:
+		for _, t := range rec.Transfers {
+			f.index.transferKeys[transferKey(rec.Address, t)] = true
+		}
+		f.index.tokenTransfers[rec.Address] = rec.Transfers
+	}
+}
+
+// append writes one record as a single line and fsyncs it, compacting the
+// log first if it has grown enough. Every earlier record has been applied
+// by then, so the snapshot misses nothing.
+// Caller must hold f.mu.
+func (f *fileStorage) append(rec logRecord) error {
+	f.maybeCompact()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	n, err := f.file.Write(append(data, '\n'))
+	f.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write storage log: %w", err)
+	}
+
+	return f.file.Sync()
+}
+
+func (f *fileStorage) SubscribeAddress(sub Subscription) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sub.Address = strings.ToLower(sub.Address)
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = time.Now().UTC()
+	}
+
+	f.index.mu.RLock()
+	exists := f.index.isSubscribed(sub.Address)
+	f.index.mu.RUnlock()
+	if exists {
+		return false
+	}
+
+	rec := logRecord{Op: RECORD_SUBSCRIBE, Address: sub.Address, Subscription: &sub}
+	if err := f.append(rec); err != nil {
+		log.Printf("[ERROR] Failed to persist subscription %s: %v", sub.Address, err)
+		return false
+	}
+
+	f.apply(rec)
+
+	return true
+}
+
+func (f *fileStorage) UnsubscribeAddress(addr string, purge bool) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a := strings.ToLower(addr)
+
+	f.index.mu.RLock()
+	exists := f.index.isSubscribed(a)
+	f.index.mu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	rec := logRecord{Op: RECORD_UNSUBSCRIBE, Address: a, Purge: purge}
+	if err := f.append(rec); err != nil {
+		log.Printf("[ERROR] Failed to persist unsubscription %s: %v", a, err)
+		return false
+	}
+
+	f.apply(rec)
+
+	return true
+}
+
+func (f *fileStorage) ListSubscriptions() []Subscription {
+	return f.index.ListSubscriptions()
+}
+
+func (f *fileStorage) GetSubscribedAddresses() []string {
+	return f.index.GetSubscribedAddresses()
+}
+
+// StoreBlockTransactions persists the subscribed TXs and token transfers of
+// a block together with its header in one record, so the block is either
+// fully stored or not at all.
+func (f *fileStorage) StoreBlockTransactions(header BlockHeader, txs []Transaction, transfers []TokenTransfer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.index.mu.RLock()
+	matched := f.index.matching(txs)
+	matchedTransfers := f.index.matchingTransfers(transfers)
+	f.index.mu.RUnlock()
+
+	rec := logRecord{
+		Op:           RECORD_BLOCK,
+		Block:        header.Number,
+		Header:       &header,
+		Transactions: matched,
+		Transfers:    matchedTransfers,
+	}
+
+	if err := f.append(rec); err != nil {
+		return err
+	}
+
+	f.apply(rec)
+
+	for _, tx := range matched {
+		log.Println(tx)
+	}
+
+	return nil
+}
+
+// StoreBackfillTransactions persists the TXs and token transfers of an
+// older block touching `addr` in one record.
+func (f *fileStorage) StoreBackfillTransactions(addr string, header BlockHeader, txs []Transaction, transfers []TokenTransfer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.index.mu.RLock()
+	matched := f.index.backfillMatching(addr, txs)
+	matchedTransfers := f.index.backfillTransfers(addr, transfers)
+	f.index.mu.RUnlock()
+
+	if len(matched) == 0 && len(matchedTransfers) == 0 {
+		return nil
+	}
+
+	rec := logRecord{
+		Op:           RECORD_BACKFILL,
+		Address:      strings.ToLower(addr),
+		Block:        header.Number,
+		Header:       &header,
+		Transactions: matched,
+		Transfers:    matchedTransfers,
+	}
+	if err := f.append(rec); err != nil {
+		return err
+	}
+
+	f.apply(rec)
+
+	for _, tx := range matched {
+		log.Println(tx)
+	}
+
+	return nil
+}
+
+func (f *fileStorage) GetTransactions(addr string, filter TxFilter) []Transaction {
+	return f.index.GetTransactions(addr, filter)
+}
+
+func (f *fileStorage) QueryTransactions(addr string, query TxQuery) (TxPage, error) {
+	return f.index.QueryTransactions(addr, query)
+}
+
+func (f *fileStorage) GetTokenTransfers(addr string) []TokenTransfer {
+	return f.index.GetTokenTransfers(addr)
+}
+
+func (f *fileStorage) AddGaps(blocks []int) error {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec := logRecord{Op: RECORD_GAPS, Blocks: blocks}
+	if err := f.append(rec); err != nil {
+		return err
+	}
+
+	f.apply(rec)
+
+	return nil
+}
+
+func (f *fileStorage) RemoveGap(block int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.index.mu.RLock()
+	exists := f.index.isGap(block)
+	f.index.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+
+	rec := logRecord{Op: RECORD_GAP_FILLED, Block: block}
+	if err := f.append(rec); err != nil {
+		return err
+	}
+
+	f.apply(rec)
+
+	return nil
+}
+
+func (f *fileStorage) GetGaps() []int {
+	return f.index.GetGaps()
+}
+
+func (f *fileStorage) RollbackBlocks(fromBlock int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rec := logRecord{Op: RECORD_ROLLBACK, Block: fromBlock}
+	if err := f.append(rec); err != nil {
+		return err
+	}
+
+	f.apply(rec)
+
+	return nil
+}
+
+func (f *fileStorage) GetRecentHeaders() []BlockHeader {
+	return f.index.GetRecentHeaders()
+}
+
+func (f *fileStorage) GetLastStoredBlock() int {
+	return f.index.GetLastStoredBlock()
+}
+
+func (f *fileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	return f.file.Close()
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	ALICE = "0x00000000000000000000000000000000000000a1"
+	BOB   = "0x00000000000000000000000000000000000000b0"
+)
+
+// openLog writes `content` as a storage log and opens it.
+func openLog(t *testing.T, content string) (Storage, string, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "storage.log")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sto, err := NewFileStorage(path)
+	if err == nil {
+		t.Cleanup(func() { sto.Close() })
+	}
+
+	return sto, path, err
+}
+
+func TestFileStorageReplay(t *testing.T) {
+	subscribe := `{"op":"subscribe","address":"` + ALICE + `","block":0}` + "\n"
+	block := func(n string) string {
+		return `{"op":"block","block":` + n + `,"header":{"Number":` + n + `},"txs":[{"Hash":"0x` + n + `","From":"` + ALICE + `","To":"` + BOB + `","BlockNumber":` + n + `,"Value":"1"}]}` + "\n"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		txs     int
+		last    int
+	}{
+		{"empty", "", false, 0, -1},
+		{"valid", subscribe + block("1") + block("2"), false, 2, 2},
+		{"torn tail", subscribe + block("1") + `{"op":"block","blo`, false, 1, 1},
+		{"corrupt last line", subscribe + block("1") + "not json\n", false, 1, 1},
+		{"corrupt middle", subscribe + "not json\n" + block("1") + block("2"), true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sto, path, err := openLog(t, tt.content)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error for a corrupt record followed by valid ones")
+				}
+				data, _ := os.ReadFile(path)
+				if string(data) != tt.content {
+					t.Error("the log was modified despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := len(sto.GetTransactions(ALICE, TxFilter{})); got != tt.txs {
+				t.Errorf("got %d transactions, want %d", got, tt.txs)
+			}
+			if got := sto.GetLastStoredBlock(); got != tt.last {
+				t.Errorf("last stored block = %d, want %d", got, tt.last)
+			}
+		})
+	}
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	sto, path, err := openLog(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sto.SubscribeAddress(Subscription{Address: ALICE, Label: "alice"})
+	sto.SubscribeAddress(Subscription{Address: BOB})
+	for n := 1; n <= 5; n++ {
+		tx := Transaction{Hash: "0x" + strings.Repeat("1", n), From: ALICE, To: BOB, BlockNumber: n, Value: "1"}
+		var transfers []TokenTransfer
+		if n == 3 {
+			transfers = []TokenTransfer{{TxHash: tx.Hash, BlockNumber: 3, From: ALICE, To: BOB, Value: "5"}}
+		}
+		if err := sto.StoreBlockTransactions(BlockHeader{Number: n, Hash: "0xh"}, []Transaction{tx}, transfers); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sto.RollbackBlocks(5)
+	sto.AddGaps([]int{2})
+	sto.UnsubscribeAddress(BOB, false)
+
+	want := snapshotOf(sto)
+	sto.Close()
+
+	reopened, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	compacted, _ := os.ReadFile(path)
+	for _, op := range []string{RECORD_ROLLBACK, RECORD_UNSUBSCRIBE, RECORD_TOKENS} {
+		if strings.Contains(string(compacted), `"op":"`+op+`"`) {
+			t.Errorf("compacted log still holds %s records", op)
+		}
+	}
+
+	if got := snapshotOf(reopened); !reflect.DeepEqual(got, want) {
+		t.Errorf("state changed by compaction:\n got %+v\nwant %+v", got, want)
+	}
+
+	// The compacted log keeps accepting records
+	tx := Transaction{Hash: "0x6", From: ALICE, BlockNumber: 6, Value: "1"}
+	if err := reopened.StoreBlockTransactions(BlockHeader{Number: 6}, []Transaction{tx}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.GetLastStoredBlock(); got != 6 {
+		t.Errorf("last stored block = %d, want 6", got)
+	}
+}
+
+func TestRecentHeaders(t *testing.T) {
+	sto, path, err := openLog(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks without subscribed TXs keep their header too
+	for n := 1; n <= 100; n++ {
+		if err := sto.StoreBlockTransactions(BlockHeader{Number: n, Hash: fmt.Sprintf("0x%x", n)}, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sto.RollbackBlocks(91)
+
+	check := func(sto Storage) {
+		t.Helper()
+
+		recent := sto.GetRecentHeaders()
+		if len(recent) != RECENT_HEADERS-10 || recent[0].Number != 37 || recent[len(recent)-1].Number != 90 {
+			t.Fatalf("recent headers span %d..%d (%d), want 37..90",
+				recent[0].Number, recent[len(recent)-1].Number, len(recent))
+		}
+	}
+
+	check(sto)
+	sto.Close()
+
+	reopened, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	check(reopened)
+}
+
+type storageState struct {
+	Subscriptions []Subscription
+	Alice, Bob    []Transaction
+	AliceTokens   []TokenTransfer
+	BobTokens     []TokenTransfer
+	Gaps          []int
+	Recent        []BlockHeader
+	Last          int
+}
+
+func snapshotOf(sto Storage) storageState {
+	return storageState{
+		Subscriptions: sto.ListSubscriptions(),
+		Alice:         sto.GetTransactions(ALICE, TxFilter{}),
+		Bob:           sto.GetTransactions(BOB, TxFilter{}),
+		AliceTokens:   sto.GetTokenTransfers(ALICE),
+		BobTokens:     sto.GetTokenTransfers(BOB),
+		Gaps:          sto.GetGaps(),
+		Recent:        sto.GetRecentHeaders(),
+		Last:          sto.GetLastStoredBlock(),
+	}
+}
+
+func TestStoreBlockOnce(t *testing.T) {
+	sto, path, err := openLog(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sto.SubscribeAddress(Subscription{Address: ALICE})
+
+	header := BlockHeader{Number: 4, Hash: "0x4"}
+	txs := []Transaction{
+		{Hash: "0xa", From: ALICE, To: BOB, BlockNumber: 4, Value: "1"},
+		{Hash: "0xa", From: ALICE, To: BOB, BlockNumber: 4, Value: "1", Kind: TX_KIND_INTERNAL, TraceAddress: "0"},
+	}
+	transfers := []TokenTransfer{{TxHash: "0xa", BlockNumber: 4, From: ALICE, To: BOB, Value: "5"}}
+
+	// The block is stored again, e.g. refetched after a crash
+	for i := 0; i < 2; i++ {
+		if err := sto.StoreBlockTransactions(header, txs, transfers); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(t *testing.T, sto Storage) {
+		t.Helper()
+
+		if got := sto.GetTransactions(ALICE, TxFilter{}); len(got) != 2 {
+			t.Errorf("%d transactions stored, want 2", len(got))
+		}
+		if got := sto.GetTokenTransfers(ALICE); len(got) != 1 {
+			t.Errorf("%d token transfers stored, want 1", len(got))
+		}
+	}
+	check(t, sto)
+
+	content, _ := os.ReadFile(path)
+	if strings.Contains(string(content), `"op":"`+RECORD_TOKENS+`"`) {
+		t.Error("token transfers written apart from their block")
+	}
+
+	// Replaying both block records doesn't duplicate either
+	sto.Close()
+	reopened, err := NewFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	check(t, reopened)
+}
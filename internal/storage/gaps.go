@@ -0,0 +1,51 @@
+package storage
+
+import "sort"
+
+func (m *memoryStorage) AddGaps(blocks []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.addGaps(blocks)
+
+	return nil
+}
+
+// addGaps marks `blocks` as missing. Caller must hold m.mu.
+func (m *memoryStorage) addGaps(blocks []int) {
+	for _, b := range blocks {
+		m.gaps[b] = true
+	}
+}
+
+func (m *memoryStorage) RemoveGap(block int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.gaps, block)
+
+	return nil
+}
+
+func (m *memoryStorage) GetGaps() []int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedGaps()
+}
+
+// sortedGaps returns the gap list ascending. Caller must hold m.mu.
+func (m *memoryStorage) sortedGaps() []int {
+	gaps := make([]int, 0, len(m.gaps))
+	for b := range m.gaps {
+		gaps = append(gaps, b)
+	}
+	sort.Ints(gaps)
+
+	return gaps
+}
+
+// isGap reports whether `block` is in the gap list. Caller must hold m.mu.
+func (m *memoryStorage) isGap(block int) bool {
+	return m.gaps[block]
+}
@@ -1,26 +1,134 @@
 package storage
 
-// Transaction captures minimal TX data.
-type Transaction struct {
-	Hash        string
-	From        string
-	To          string
-	BlockNumber int
-	Value       string
-}
+const (
+	TX_KIND_EXTERNAL = "external" // a transaction sent by an account
+	TX_KIND_INTERNAL = "internal" // a value transfer made by a contract call within one
+)
+
+type (
+	// Transaction captures a stored TX. Quantities are decimal strings.
+	Transaction struct {
+		Kind             string // TX_KIND_EXTERNAL or TX_KIND_INTERNAL
+		Hash             string
+		From             string
+		To               string
+		BlockNumber      int
+		TransactionIndex int
+		Value            string
+
+		// Envelope fields of external TXs. Fee fields only appear for the
+		// types that have them.
+		Type                 string          `json:",omitempty"` // legacy, access-list, dynamic-fee, blob or set-code
+		ChainID              string          `json:",omitempty"`
+		Nonce                string          `json:",omitempty"`
+		Gas                  string          `json:",omitempty"`
+		GasPrice             string          `json:",omitempty"`
+		MaxFeePerGas         string          `json:",omitempty"`
+		MaxPriorityFeePerGas string          `json:",omitempty"`
+		MaxFeePerBlobGas     string          `json:",omitempty"`
+		Input                string          `json:",omitempty"`
+		AccessList           []AccessTuple   `json:",omitempty"`
+		BlobVersionedHashes  []string        `json:",omitempty"`
+		AuthorizationList    []Authorization `json:",omitempty"`
+
+		// Receipt fields, empty unless receipts are fetched.
+		ExecutionStatus   string `json:",omitempty"`
+		GasUsed           string `json:",omitempty"`
+		EffectiveGasPrice string `json:",omitempty"`
+		ContractAddress   string `json:",omitempty"`
+		BlobGasUsed       string `json:",omitempty"`
+		BlobGasPrice      string `json:",omitempty"`
+
+		// Fees paid, in wei, derived from the receipt and the block base fee.
+		// Fee is the execution fee (GasUsed × EffectiveGasPrice), split into
+		// the BurnedFee (base fee) and the PriorityFee tip; BlobFee is paid
+		// on top of it by blob transactions.
+		Fee         string `json:",omitempty"`
+		BurnedFee   string `json:",omitempty"`
+		PriorityFee string `json:",omitempty"`
+		BlobFee     string `json:",omitempty"`
+
+		// Internal call fields. Hash is the one of the enclosing transaction.
+		CallType     string `json:",omitempty"` // CALL, CREATE, SELFDESTRUCT...
+		TraceAddress string `json:",omitempty"` // position in the call tree, e.g. "0-2"
+
+		// Block is joined on read; it is not stored with each TX.
+		Block *BlockHeader `json:",omitempty"`
+	}
+
+	// AccessTuple is one entry of an EIP-2930 access list.
+	AccessTuple struct {
+		Address     string
+		StorageKeys []string
+	}
+
+	// Authorization is one entry of an EIP-7702 authorization list.
+	Authorization struct {
+		ChainID string
+		Address string
+		Nonce   string
+	}
+)
 
 // Storage is an interface for storing and retrieving TXs.
 type Storage interface {
-	// SubscribeAddress adds an address for tracking.
-	SubscribeAddress(addr string) bool
+	// SubscribeAddress adds an address for tracking. CreatedAt defaults to now.
+	SubscribeAddress(sub Subscription) bool
+
+	// UnsubscribeAddress stops tracking an address. With `purge` its stored
+	// TXs and token transfers are dropped; otherwise they stay queryable.
+	UnsubscribeAddress(addr string, purge bool) bool
+
+	// ListSubscriptions returns every subscription, oldest first.
+	ListSubscriptions() []Subscription
 
 	// GetSubscribedAddresses returns all subscribed addresses.
 	GetSubscribedAddresses() []string
 
-	// StoreBlockTransactions does an atomic insertion of all TXs for a block.
-	// Only stores if TX's 'from' or 'to' is subscribed.
-	StoreBlockTransactions(blockNum int, txs []Transaction) error
+	// StoreBlockTransactions does an atomic insertion of all TXs for a block,
+	// internal ones included, and of its token transfers. Only stores if
+	// TX's 'from', 'to' or created contract address is subscribed, or
+	// transfer's 'from' or 'to'; the header is kept if any TX is. Storing
+	// the same TX or event twice is a no-op.
+	StoreBlockTransactions(header BlockHeader, txs []Transaction, transfers []TokenTransfer) error
+
+	// StoreBackfillTransactions stores the TXs and token transfers of an
+	// older block touching `addr` under that address only, skipping those
+	// it already holds. It doesn't move the checkpoint.
+	StoreBackfillTransactions(addr string, header BlockHeader, txs []Transaction, transfers []TokenTransfer) error
+
+	// GetTransactions returns the stored TXs of an address passing `filter`,
+	// sorted by block and joined with their block header.
+	GetTransactions(addr string, filter TxFilter) []Transaction
+
+	// QueryTransactions returns one page of the TXs of an address passing
+	// the query filter, in the query order. Fails on a malformed cursor.
+	QueryTransactions(addr string, query TxQuery) (TxPage, error)
+
+	// GetTokenTransfers returns stored token transfers for an address, sorted by block.
+	GetTokenTransfers(addr string) []TokenTransfer
+
+	// AddGaps records blocks that could not be fetched, to be repaired later.
+	AddGaps(blocks []int) error
+
+	// RemoveGap drops a block from the gap list once it is stored.
+	RemoveGap(block int) error
+
+	// GetGaps returns the blocks still missing below the checkpoint, ascending.
+	GetGaps() []int
+
+	// RollbackBlocks removes every stored TX, token transfer and gap from `fromBlock` onwards and
+	// moves the checkpoint back to fromBlock-1. Used when a chain reorg orphans blocks.
+	RollbackBlocks(fromBlock int) error
+
+	// GetRecentHeaders returns the headers of the last RECENT_HEADERS
+	// stored blocks, ascending, matched TXs or not.
+	GetRecentHeaders() []BlockHeader
+
+	// GetLastStoredBlock returns the highest block fully stored, or -1 if none.
+	// It is the checkpoint block processing resumes from after a restart.
+	GetLastStoredBlock() int
 
-	// GetTransactions returns stored TXs for a specific address, sorted by block.
-	GetTransactions(addr string) []Transaction
+	// Close flushes and releases any underlying resources.
+	Close() error
 }
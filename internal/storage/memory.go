@@ -2,86 +2,319 @@ package storage
 
 import (
 	"log"
+	"sort"
 	"strings"
 	"sync"
 )
 
 type memoryStorage struct {
-	mu           sync.RWMutex
-	subscribed   map[string]bool
-	transactions map[string][]Transaction
+	mu             sync.RWMutex
+	subscribed     map[string]Subscription
+	transactions   map[string][]Transaction
+	txKeys         map[string]bool
+	headers        map[int]BlockHeader
+	recent         map[int]BlockHeader // last RECENT_HEADERS stored blocks
+	tokenTransfers map[string][]TokenTransfer
+	transferKeys   map[string]bool
+	gaps           map[int]bool
+	lastBlock      int
 }
 
 // NewMemoryStorage returns an in-memory implementation of Storage.
 func NewMemoryStorage() Storage {
+	return newMemoryStorage()
+}
+
+func newMemoryStorage() *memoryStorage {
 	return &memoryStorage{
-		subscribed:   make(map[string]bool),
-		transactions: make(map[string][]Transaction),
+		subscribed:     make(map[string]Subscription),
+		transactions:   make(map[string][]Transaction),
+		txKeys:         make(map[string]bool),
+		headers:        make(map[int]BlockHeader),
+		recent:         make(map[int]BlockHeader),
+		tokenTransfers: make(map[string][]TokenTransfer),
+		transferKeys:   make(map[string]bool),
+		gaps:           make(map[int]bool),
+		lastBlock:      -1,
 	}
 }
 
-func (m *memoryStorage) SubscribeAddress(addr string) bool {
+func (m *memoryStorage) StoreBlockTransactions(header BlockHeader, txs []Transaction, transfers []TokenTransfer) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	a := strings.ToLower(addr)
-	if m.subscribed[a] {
-		return false
+	for _, tx := range m.storeBlock(header, txs, transfers) {
+		log.Println(tx)
 	}
 
-	m.subscribed[a] = true
+	return nil
+}
+
+// matching returns the subset of txs touching a subscribed address.
+// Caller must hold m.mu (read or write).
+func (m *memoryStorage) matching(txs []Transaction) []Transaction {
+	var matched []Transaction
+	for _, tx := range txs {
+		if len(m.subscribedParties(tx)) > 0 {
+			matched = append(matched, tx)
+		}
+	}
 
-	return true
+	return matched
 }
 
-func (m *memoryStorage) GetSubscribedAddresses() []string {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// subscribedParties returns the subscribed addresses a TX belongs to: its
+// sender, its recipient and, for contract creations, the new contract.
+// Caller must hold m.mu (read or write).
+func (m *memoryStorage) subscribedParties(tx Transaction) []string {
+	var parties []string
+	seen := make(map[string]bool)
+	for _, addr := range []string{tx.From, tx.To, tx.ContractAddress} {
+		a := strings.ToLower(addr)
+		if a == "" || !m.isSubscribed(a) || seen[a] {
+			continue
+		}
+		seen[a] = true
+
+		parties = append(parties, a)
+	}
+
+	return parties
+}
+
+// storeBlock indexes the subscribed TXs and token transfers of a block, and
+// its header if any TX matched, and returns the TXs. TXs already stored for
+// a party are skipped, so re-processing a block is harmless.
+// Caller must hold m.mu.
+func (m *memoryStorage) storeBlock(header BlockHeader, txs []Transaction, transfers []TokenTransfer) []Transaction {
+	matched := m.matching(txs)
+	for i, tx := range matched {
+		// Records written before internal TXs were tracked carry no kind.
+		if tx.Kind == "" {
+			tx.Kind = TX_KIND_EXTERNAL
+			matched[i] = tx
+		}
+
+		for _, a := range m.subscribedParties(tx) {
+			key := addressTxKey(a, tx)
+			if m.txKeys[key] {
+				continue
+			}
+
+			m.txKeys[key] = true
+			m.transactions[a] = insertByKey(m.transactions[a], tx)
+		}
+	}
+
+	m.storeTokenTransfers(m.matchingTransfers(transfers))
+
+	if len(matched) > 0 {
+		m.headers[header.Number] = header
+	}
+
+	m.keepRecent(header)
 
-	var addrs []string
-	for k := range m.subscribed {
-		addrs = append(addrs, k)
+	if header.Number > m.lastBlock {
+		m.lastBlock = header.Number
 	}
 
-	return addrs
+	return matched
 }
 
-func (m *memoryStorage) StoreBlockTransactions(blockNum int, txs []Transaction) error {
+func (m *memoryStorage) StoreBackfillTransactions(addr string, header BlockHeader, txs []Transaction, transfers []TokenTransfer) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	for _, tx := range m.storeBackfill(addr, header, txs, transfers) {
+		log.Println(tx)
+	}
+
+	return nil
+}
+
+// backfillMatching returns the TXs of a block touching `addr` that aren't
+// stored for it yet, or none once `addr` is unsubscribed.
+// Caller must hold m.mu (read or write).
+func (m *memoryStorage) backfillMatching(addr string, txs []Transaction) []Transaction {
+	a := strings.ToLower(addr)
+	if !m.isSubscribed(a) {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var matched []Transaction
 	for _, tx := range txs {
-		from := strings.ToLower(tx.From)
-		to := strings.ToLower(tx.To)
-		if m.subscribed[from] || m.subscribed[to] {
-			// store in the 'from' address bucket if subscribed
-			if m.subscribed[from] {
-				m.transactions[from] = append(m.transactions[from], tx)
-			}
+		touches := false
+		for _, party := range []string{tx.From, tx.To, tx.ContractAddress} {
+			touches = touches || strings.EqualFold(party, a)
+		}
 
-			// store in the 'to' address bucket if subscribed
-			if m.subscribed[to] {
-				m.transactions[to] = append(m.transactions[to], tx)
-			}
+		key := addressTxKey(a, tx)
+		if touches && !m.txKeys[key] && !seen[key] {
+			seen[key] = true
+			matched = append(matched, tx)
+		}
+	}
+
+	return matched
+}
+
+// storeBackfill inserts the matching TXs and token transfers of an older
+// block under `addr`, keeping its TXs sorted by key, and returns the TXs.
+// Caller must hold m.mu.
+func (m *memoryStorage) storeBackfill(addr string, header BlockHeader, txs []Transaction, transfers []TokenTransfer) []Transaction {
+	a := strings.ToLower(addr)
+
+	m.storeBackfillTokenTransfers(a, m.backfillTransfers(a, transfers))
+
+	matched := m.backfillMatching(a, txs)
+	if len(matched) == 0 {
+		return nil
+	}
+
+	for _, tx := range matched {
+		m.txKeys[addressTxKey(a, tx)] = true
+	}
+	m.transactions[a] = insertByKey(m.transactions[a], matched...)
+
+	m.headers[header.Number] = header
+
+	return matched
+}
+
+// insertByKey adds `txs` to `existing`, keeping the list sorted by key so
+// queries can binary-search it. New blocks are simply appended; a repaired
+// or backfilled block is merged in place.
+func insertByKey(existing []Transaction, txs ...Transaction) []Transaction {
+	if len(txs) == 0 {
+		return existing
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool { return keyOf(txs[i]).less(keyOf(txs[j])) })
+
+	if len(existing) == 0 || keyOf(existing[len(existing)-1]).less(keyOf(txs[0])) {
+		return append(existing, txs...)
+	}
 
-			log.Println(tx)
+	merged := make([]Transaction, 0, len(existing)+len(txs))
+	for len(existing) > 0 && len(txs) > 0 {
+		if keyOf(txs[0]).less(keyOf(existing[0])) {
+			merged = append(merged, txs[0])
+			txs = txs[1:]
+		} else {
+			merged = append(merged, existing[0])
+			existing = existing[1:]
 		}
 	}
+	merged = append(merged, existing...)
 
-	return nil
+	return append(merged, txs...)
+}
+
+// addressTxKey identifies `tx`, internal calls included, as stored under
+// address `a`.
+func addressTxKey(a string, tx Transaction) string {
+	return a + "|" + strings.ToLower(tx.Hash) + "|" + tx.TraceAddress
 }
 
-func (m *memoryStorage) GetTransactions(addr string) []Transaction {
+func (m *memoryStorage) GetTransactions(addr string, filter TxFilter) []Transaction {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	a := strings.ToLower(addr)
-	txs := m.transactions[a]
 
-	if txs == nil {
-		return []Transaction{}
+	txs := []Transaction{}
+	for _, tx := range m.transactions[a] {
+		if tx, ok := m.filtered(a, tx, filter); ok {
+			txs = append(txs, tx)
+		}
 	}
 
-	// copy the slice
-	return txs[:]
+	return txs
+}
+
+func (m *memoryStorage) QueryTransactions(addr string, query TxQuery) (TxPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a := strings.ToLower(addr)
+
+	return paginate(m.transactions[a], query, func(tx Transaction) (Transaction, bool) {
+		return m.filtered(a, tx, query.TxFilter)
+	})
+}
+
+// filtered reports whether `tx` of address `addr` passes the filter and
+// returns it with its block header. Caller must hold m.mu (read or write).
+func (m *memoryStorage) filtered(addr string, tx Transaction, filter TxFilter) (Transaction, bool) {
+	var block *BlockHeader
+	if header, ok := m.headers[tx.BlockNumber]; ok {
+		block = &header
+	}
+
+	if !filter.matches(addr, tx, block) {
+		return tx, false
+	}
+
+	tx.Block = block
+	return tx, true
+}
+
+func (m *memoryStorage) RollbackBlocks(fromBlock int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollback(fromBlock)
+
+	return nil
+}
+
+// rollback drops every TX at or above fromBlock. Caller must hold m.mu.
+func (m *memoryStorage) rollback(fromBlock int) {
+	for addr, txs := range m.transactions {
+		kept := txs[:0]
+		for _, tx := range txs {
+			if tx.BlockNumber < fromBlock {
+				kept = append(kept, tx)
+				continue
+			}
+			delete(m.txKeys, addressTxKey(addr, tx))
+		}
+		m.transactions[addr] = kept
+	}
+
+	for block := range m.headers {
+		if block >= fromBlock {
+			delete(m.headers, block)
+		}
+	}
+
+	for block := range m.recent {
+		if block >= fromBlock {
+			delete(m.recent, block)
+		}
+	}
+
+	m.rollbackTokenTransfers(fromBlock)
+
+	for block := range m.gaps {
+		if block >= fromBlock {
+			delete(m.gaps, block)
+		}
+	}
+
+	if m.lastBlock >= fromBlock {
+		m.lastBlock = fromBlock - 1
+	}
+}
+
+func (m *memoryStorage) GetLastStoredBlock() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.lastBlock
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
 }
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DIRECTION_IN  = "in"
+	DIRECTION_OUT = "out"
+
+	ORDER_ASC  = "asc"
+	ORDER_DESC = "desc"
+)
+
+// errInvalidCursor is returned for a cursor this storage didn't issue.
+var errInvalidCursor = errors.New("invalid cursor")
+
+type (
+	// TxQuery selects one page of the TXs of an address.
+	TxQuery struct {
+		TxFilter
+		Order  string // ORDER_ASC (default) or ORDER_DESC
+		Cursor string // Next of the previous page, empty for the first one
+		Limit  int    // 0 means no limit
+	}
+
+	// TxPage is one page of TXs. Next is the cursor of the following page,
+	// empty on the last one.
+	TxPage struct {
+		Transactions []Transaction
+		Next         string
+	}
+
+	// txKey orders the TXs of an address: by block, by position in the
+	// block, then internal calls after their enclosing TX.
+	txKey struct {
+		block        int
+		index        int
+		traceAddress string
+	}
+)
+
+func keyOf(tx Transaction) txKey {
+	return txKey{block: tx.BlockNumber, index: tx.TransactionIndex, traceAddress: tx.TraceAddress}
+}
+
+func (k txKey) less(o txKey) bool {
+	if k.block != o.block {
+		return k.block < o.block
+	}
+
+	if k.index != o.index {
+		return k.index < o.index
+	}
+
+	return compareTraceAddresses(k.traceAddress, o.traceAddress) < 0
+}
+
+// compareTraceAddresses orders positions in a call tree such as "0-2" and
+// "0-10" segment by segment, numerically, so that a call sorts right after
+// its parent and before its siblings' subtrees. The enclosing TX ("") comes
+// first.
+func compareTraceAddresses(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return -1
+	}
+	if b == "" {
+		return 1
+	}
+
+	as, bs := strings.Split(a, "-"), strings.Split(b, "-")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+		if errX != nil || errY != nil {
+			return strings.Compare(as[i], bs[i])
+		}
+		if x < y {
+			return -1
+		}
+		return 1
+	}
+
+	return len(as) - len(bs)
+}
+
+// encodeCursor makes `k` an opaque cursor.
+func encodeCursor(k txKey) string {
+	raw := fmt.Sprintf("%d:%d:%s", k.block, k.index, k.traceAddress)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor reverses encodeCursor.
+func decodeCursor(cursor string) (txKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return txKey{}, errInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 3)
+	if len(parts) != 3 {
+		return txKey{}, errInvalidCursor
+	}
+
+	block, errBlock := strconv.Atoi(parts[0])
+	index, errIndex := strconv.Atoi(parts[1])
+	if errBlock != nil || errIndex != nil {
+		return txKey{}, errInvalidCursor
+	}
+
+	return txKey{block: block, index: index, traceAddress: parts[2]}, nil
+}
+
+// Cursor returns the cursor of `tx`: a query with it starts right after `tx`.
+func Cursor(tx Transaction) string {
+	return encodeCursor(keyOf(tx))
+}
+
+// BlockCursor returns the cursor positioned after every TX of block `n`.
+func BlockCursor(n int) string {
+	return encodeCursor(txKey{block: n, index: math.MaxInt32})
+}
+
+// CompareCursors returns -1, 0 or 1 as cursor `a` sorts before, with or
+// after cursor `b`.
+func CompareCursors(a, b string) (int, error) {
+	ka, err := decodeCursor(a)
+	if err != nil {
+		return 0, err
+	}
+
+	kb, err := decodeCursor(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case ka.less(kb):
+		return -1, nil
+	case kb.less(ka):
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+// paginate cuts the page following the cursor, in query order, out of
+// `txs`, which are sorted by key. The block range and the cursor are found
+// by binary search, then only the TXs `keep` accepts are copied until the
+// page is full, so a page costs the same on a busy address as on a quiet one.
+func paginate(txs []Transaction, q TxQuery, keep func(Transaction) (Transaction, bool)) (TxPage, error) {
+	desc := q.Order == ORDER_DESC
+
+	lo, hi := 0, len(txs)
+	if q.FromBlock != 0 {
+		lo = sort.Search(len(txs), func(i int) bool { return txs[i].BlockNumber >= q.FromBlock })
+	}
+	if q.ToBlock != 0 {
+		hi = sort.Search(len(txs), func(i int) bool { return txs[i].BlockNumber > q.ToBlock })
+	}
+
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return TxPage{}, err
+		}
+
+		if desc {
+			hi = min(hi, sort.Search(len(txs), func(i int) bool { return !keyOf(txs[i]).less(after) }))
+		} else {
+			lo = max(lo, sort.Search(len(txs), func(i int) bool { return after.less(keyOf(txs[i])) }))
+		}
+	}
+
+	page := TxPage{Transactions: []Transaction{}}
+	for n := 0; n < hi-lo; n++ {
+		i := lo + n
+		if desc {
+			i = hi - 1 - n
+		}
+
+		tx, ok := keep(txs[i])
+		if !ok {
+			continue
+		}
+
+		if q.Limit > 0 && len(page.Transactions) == q.Limit {
+			page.Next = encodeCursor(keyOf(page.Transactions[q.Limit-1]))
+			break
+		}
+		page.Transactions = append(page.Transactions, tx)
+	}
+
+	return page, nil
+}
+
+// matchesValue reports whether `value` (decimal wei) is at least `min`.
+func matchesValue(value string, min *big.Int) bool {
+	if min == nil {
+		return true
+	}
+
+	v, ok := new(big.Int).SetString(value, 10)
+	return ok && v.Cmp(min) >= 0
+}
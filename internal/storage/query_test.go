@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// queryStorage holds, for ALICE, one TX in block 1 and a TX with internal
+// calls "0-2" and "0-10" plus a plain TX in block 2.
+func queryStorage(t *testing.T) Storage {
+	t.Helper()
+
+	sto := NewMemoryStorage()
+	sto.SubscribeAddress(Subscription{Address: ALICE})
+
+	blocks := map[int][]Transaction{
+		1: {{Hash: "0xa", From: ALICE, BlockNumber: 1, Value: "1"}},
+		2: {
+			{Hash: "0xb", From: ALICE, BlockNumber: 2, Value: "1"},
+			{Hash: "0xb", From: ALICE, BlockNumber: 2, Value: "1", Kind: TX_KIND_INTERNAL, TraceAddress: "0-10"},
+			{Hash: "0xb", From: ALICE, BlockNumber: 2, Value: "1", Kind: TX_KIND_INTERNAL, TraceAddress: "0-2"},
+			{Hash: "0xc", From: ALICE, BlockNumber: 2, TransactionIndex: 1, Value: "1"},
+		},
+	}
+	for n := 1; n <= 2; n++ {
+		if err := sto.StoreBlockTransactions(BlockHeader{Number: n}, blocks[n], nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return sto
+}
+
+// positions lists "hash/traceAddress" of `txs`.
+func positions(txs []Transaction) []string {
+	out := []string{}
+	for _, tx := range txs {
+		out = append(out, tx.Hash+"/"+tx.TraceAddress)
+	}
+	return out
+}
+
+func TestQueryTransactionsCursor(t *testing.T) {
+	asc := []string{"0xa/", "0xb/", "0xb/0-2", "0xb/0-10", "0xc/"}
+	desc := []string{"0xc/", "0xb/0-10", "0xb/0-2", "0xb/", "0xa/"}
+
+	tests := []struct {
+		name  string
+		order string
+		limit int
+		want  []string
+	}{
+		{"asc one by one", ORDER_ASC, 1, asc},
+		{"asc by two", ORDER_ASC, 2, asc},
+		{"asc single page", ORDER_ASC, 0, asc},
+		{"desc one by one", ORDER_DESC, 1, desc},
+		{"desc by three", ORDER_DESC, 3, desc},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sto := queryStorage(t)
+
+			var got []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatal("pagination doesn't terminate")
+				}
+
+				page, err := sto.QueryTransactions(ALICE, TxQuery{Order: tt.order, Cursor: cursor, Limit: tt.limit})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, positions(page.Transactions)...)
+
+				if page.Next == "" {
+					break
+				}
+				cursor = page.Next
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func b64(raw string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func TestQueryTransactionsInvalidCursor(t *testing.T) {
+	sto := queryStorage(t)
+
+	for _, cursor := range []string{"!!", b64("1:2"), b64("x:0:"), b64("1:y:")} {
+		if _, err := sto.QueryTransactions(ALICE, TxQuery{Cursor: cursor}); err == nil {
+			t.Errorf("cursor %q: expected an error", cursor)
+		}
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, k := range []txKey{{}, {block: 7, index: 3}, {block: 7, index: 3, traceAddress: "0-10-1"}} {
+		got, err := decodeCursor(encodeCursor(k))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != k {
+			t.Errorf("got %+v, want %+v", got, k)
+		}
+	}
+
+	// BlockCursor sorts after every TX of its block and before the next one
+	tx := Transaction{BlockNumber: 2, TransactionIndex: 5, TraceAddress: "3"}
+	if c, _ := CompareCursors(Cursor(tx), BlockCursor(2)); c != -1 {
+		t.Errorf("TX of block 2 vs BlockCursor(2) = %d, want -1", c)
+	}
+	if c, _ := CompareCursors(BlockCursor(2), Cursor(Transaction{BlockNumber: 3})); c != -1 {
+		t.Errorf("BlockCursor(2) vs first TX of block 3 = %d, want -1", c)
+	}
+}
+
+func TestCompareTraceAddresses(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "0", -1},
+		{"0", "0-1", -1},
+		{"0-2", "0-10", -1},
+		{"0-10", "1", -1},
+		{"10", "9-9", 1},
+		{"0-1-5", "0-1-5", 0},
+	}
+
+	for _, tt := range tests {
+		got := compareTraceAddresses(tt.a, tt.b)
+		if (got > 0) != (tt.want > 0) || (got < 0) != (tt.want < 0) {
+			t.Errorf("compareTraceAddresses(%q, %q) = %d, want sign of %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTransactionsRange(t *testing.T) {
+	sto := NewMemoryStorage()
+	sto.SubscribeAddress(Subscription{Address: ALICE})
+
+	for n := 1; n <= 6; n++ {
+		if n == 3 {
+			continue
+		}
+		tx := Transaction{Hash: fmt.Sprintf("0x%d", n), From: ALICE, BlockNumber: n, Value: "1"}
+		if err := sto.StoreBlockTransactions(BlockHeader{Number: n}, []Transaction{tx}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Block 3 is backfilled after the newer ones
+	backfilled := Transaction{Hash: "0x3", To: ALICE, BlockNumber: 3, Value: "1"}
+	if err := sto.StoreBackfillTransactions(ALICE, BlockHeader{Number: 3}, []Transaction{backfilled}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    TxQuery
+		want     []string
+		wantNext bool
+	}{
+		{"all", TxQuery{}, []string{"0x1/", "0x2/", "0x3/", "0x4/", "0x5/", "0x6/"}, false},
+		{"block range", TxQuery{TxFilter: TxFilter{FromBlock: 2, ToBlock: 4}}, []string{"0x2/", "0x3/", "0x4/"}, false},
+		{"range desc", TxQuery{TxFilter: TxFilter{FromBlock: 2, ToBlock: 4}, Order: ORDER_DESC}, []string{"0x4/", "0x3/", "0x2/"}, false},
+		{"full page", TxQuery{TxFilter: TxFilter{ToBlock: 4}, Limit: 2}, []string{"0x1/", "0x2/"}, true},
+		{"last match fills the page", TxQuery{TxFilter: TxFilter{Direction: DIRECTION_IN}, Limit: 1}, []string{"0x3/"}, false},
+		{"cursor before the range", TxQuery{TxFilter: TxFilter{FromBlock: 5}, Cursor: BlockCursor(1)}, []string{"0x5/", "0x6/"}, false},
+		{"cursor past the range", TxQuery{TxFilter: TxFilter{ToBlock: 2}, Cursor: BlockCursor(4)}, []string{}, false},
+		{"desc from a cursor", TxQuery{Order: ORDER_DESC, Cursor: BlockCursor(3)}, []string{"0x3/", "0x2/", "0x1/"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := sto.QueryTransactions(ALICE, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := positions(page.Transactions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if (page.Next != "") != tt.wantNext {
+				t.Errorf("next %q, want one: %v", page.Next, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/buildwithme/ethparser/pkg/constants"
+	"github.com/buildwithme/ethparser/pkg/env"
+)
+
+const (
+	BACKEND_MEMORY = "memory"
+	BACKEND_FILE   = "file"
+)
+
+// New returns the Storage backend selected by the STORAGE env var.
+func New() (Storage, error) {
+	backend := env.GetEnvString(constants.ENV_STORAGE, BACKEND_MEMORY)
+
+	switch backend {
+	case BACKEND_MEMORY:
+		return NewMemoryStorage(), nil
+	case BACKEND_FILE:
+		return NewFileStorage(env.GetEnvString(constants.ENV_STORAGE_PATH, "data/ethparser.log"))
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
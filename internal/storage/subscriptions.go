@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Subscription is a watched address and its metadata.
+type Subscription struct {
+	Address    string
+	Label      string `json:",omitempty"`
+	CreatedAt  time.Time
+	StartBlock int    // first block processed for the address
+	WebhookURL string `json:",omitempty"` // receives the matched transactions of each block
+}
+
+func (m *memoryStorage) SubscribeAddress(sub Subscription) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = time.Now().UTC()
+	}
+
+	return m.subscribe(sub)
+}
+
+// subscribe marks sub.Address as subscribed. Caller must hold m.mu.
+func (m *memoryStorage) subscribe(sub Subscription) bool {
+	sub.Address = strings.ToLower(sub.Address)
+	if m.isSubscribed(sub.Address) {
+		return false
+	}
+
+	m.subscribed[sub.Address] = sub
+
+	return true
+}
+
+// isSubscribed reports whether the lowercase `addr` is watched.
+// Caller must hold m.mu (read or write).
+func (m *memoryStorage) isSubscribed(addr string) bool {
+	_, ok := m.subscribed[addr]
+	return ok
+}
+
+func (m *memoryStorage) UnsubscribeAddress(addr string, purge bool) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.unsubscribe(addr, purge)
+}
+
+// unsubscribe stops watching addr and, with `purge`, drops what was
+// collected for it. Caller must hold m.mu.
+func (m *memoryStorage) unsubscribe(addr string, purge bool) bool {
+	a := strings.ToLower(addr)
+	if !m.isSubscribed(a) {
+		return false
+	}
+
+	delete(m.subscribed, a)
+
+	if purge {
+		for _, tx := range m.transactions[a] {
+			delete(m.txKeys, addressTxKey(a, tx))
+		}
+		delete(m.transactions, a)
+
+		for _, t := range m.tokenTransfers[a] {
+			delete(m.transferKeys, transferKey(a, t))
+		}
+		delete(m.tokenTransfers, a)
+	}
+
+	return true
+}
+
+func (m *memoryStorage) GetSubscribedAddresses() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var addrs []string
+	for k := range m.subscribed {
+		addrs = append(addrs, k)
+	}
+
+	return addrs
+}
+
+func (m *memoryStorage) ListSubscriptions() []Subscription {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	subs := make([]Subscription, 0, len(m.subscribed))
+	for _, s := range m.subscribed {
+		subs = append(subs, s)
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subs[i].Address < subs[j].Address
+	})
+
+	return subs
+}
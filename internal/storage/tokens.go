@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	STANDARD_ERC20   = "ERC-20"
+	STANDARD_ERC721  = "ERC-721"
+	STANDARD_ERC1155 = "ERC-1155"
+)
+
+// TokenTransfer captures a decoded token transfer event.
+type TokenTransfer struct {
+	TxHash      string
+	LogIndex    int
+	BatchIndex  int `json:",omitempty"` // position within an ERC-1155 TransferBatch
+	BlockNumber int
+	Standard    string
+	Contract    string
+	From        string
+	To          string
+	TokenID     string `json:",omitempty"` // ERC-721 and ERC-1155 only
+	Value       string
+}
+
+// IsNFT reports whether the transfer moves a non-fungible (ERC-721) or
+// multi-token (ERC-1155) asset.
+func (t TokenTransfer) IsNFT() bool {
+	return t.Standard == STANDARD_ERC721 || t.Standard == STANDARD_ERC1155
+}
+
+// key identifies the event (and batch entry) a TokenTransfer was decoded from.
+func (t TokenTransfer) key() string {
+	return fmt.Sprintf("%s:%d:%d", strings.ToLower(t.TxHash), t.LogIndex, t.BatchIndex)
+}
+
+// matchingTransfers returns the transfers sent or received by a subscribed
+// address. Caller must hold m.mu (read or write).
+func (m *memoryStorage) matchingTransfers(transfers []TokenTransfer) []TokenTransfer {
+	var matched []TokenTransfer
+	for _, t := range transfers {
+		if m.isSubscribed(strings.ToLower(t.From)) || m.isSubscribed(strings.ToLower(t.To)) {
+			matched = append(matched, t)
+		}
+	}
+
+	return matched
+}
+
+// storeTokenTransfers indexes transfers under their subscribed parties,
+// skipping events already stored for a party so re-processing a block is
+// harmless. Caller must hold m.mu.
+func (m *memoryStorage) storeTokenTransfers(transfers []TokenTransfer) {
+	for _, t := range transfers {
+		for _, a := range []string{strings.ToLower(t.From), strings.ToLower(t.To)} {
+			key := transferKey(a, t)
+			if !m.isSubscribed(a) || m.transferKeys[key] {
+				continue
+			}
+
+			m.transferKeys[key] = true
+			m.tokenTransfers[a] = append(m.tokenTransfers[a], t)
+		}
+	}
+}
+
+// backfillTransfers returns the transfers sent or received by `addr` that
+// aren't stored for it yet. Caller must hold m.mu (read or write).
+func (m *memoryStorage) backfillTransfers(addr string, transfers []TokenTransfer) []TokenTransfer {
+	a := strings.ToLower(addr)
+	if !m.isSubscribed(a) {
+		return nil
+	}
+
+	var matched []TokenTransfer
+	for _, t := range transfers {
+		if (strings.EqualFold(t.From, a) || strings.EqualFold(t.To, a)) && !m.transferKeys[transferKey(a, t)] {
+			matched = append(matched, t)
+		}
+	}
+
+	return matched
+}
+
+// storeBackfillTokenTransfers indexes transfers of an older block under
+// `addr` alone, keeping its transfers sorted by block. Caller must hold m.mu.
+func (m *memoryStorage) storeBackfillTokenTransfers(addr string, transfers []TokenTransfer) {
+	a := strings.ToLower(addr)
+
+	for _, t := range transfers {
+		key := transferKey(a, t)
+		if m.transferKeys[key] {
+			continue
+		}
+		m.transferKeys[key] = true
+
+		existing := m.tokenTransfers[a]
+		i := sort.Search(len(existing), func(i int) bool { return existing[i].BlockNumber > t.BlockNumber })
+		existing = append(existing, TokenTransfer{})
+		copy(existing[i+1:], existing[i:])
+		existing[i] = t
+		m.tokenTransfers[a] = existing
+	}
+}
+
+// transferKey identifies `t` as stored under address `a`.
+func transferKey(a string, t TokenTransfer) string {
+	return a + "|" + t.key()
+}
+
+func (m *memoryStorage) GetTokenTransfers(addr string) []TokenTransfer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]TokenTransfer{}, m.tokenTransfers[strings.ToLower(addr)]...)
+}
+
+// rollbackTokenTransfers drops every transfer at or above fromBlock.
+// Caller must hold m.mu.
+func (m *memoryStorage) rollbackTokenTransfers(fromBlock int) {
+	for addr, transfers := range m.tokenTransfers {
+		kept := transfers[:0]
+		for _, t := range transfers {
+			if t.BlockNumber < fromBlock {
+				kept = append(kept, t)
+				continue
+			}
+			delete(m.transferKeys, transferKey(addr, t))
+		}
+		m.tokenTransfers[addr] = kept
+	}
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreBackfillTransfers(t *testing.T) {
+	open := map[string]func(t *testing.T) Storage{
+		"memory": func(t *testing.T) Storage { return NewMemoryStorage() },
+		"file": func(t *testing.T) Storage {
+			sto, err := NewFileStorage(filepath.Join(t.TempDir(), "storage.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { sto.Close() })
+			return sto
+		},
+	}
+
+	for name, newStorage := range open {
+		t.Run(name, func(t *testing.T) {
+			sto := newStorage(t)
+			sto.SubscribeAddress(Subscription{Address: ALICE})
+			sto.SubscribeAddress(Subscription{Address: BOB})
+
+			// A newer transfer is already stored for both
+			sto.StoreBlockTransactions(BlockHeader{Number: 9}, nil, []TokenTransfer{{TxHash: "0x9", BlockNumber: 9, From: ALICE, To: BOB, Value: "1"}})
+
+			old := []TokenTransfer{{TxHash: "0x3", BlockNumber: 3, From: ALICE, To: BOB, Value: "2"}}
+			for i := 0; i < 2; i++ {
+				if err := sto.StoreBackfillTransactions(ALICE, BlockHeader{Number: 3}, nil, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			alice := sto.GetTokenTransfers(ALICE)
+			if len(alice) != 2 || alice[0].BlockNumber != 3 || alice[1].BlockNumber != 9 {
+				t.Errorf("alice transfers = %+v, want blocks [3 9] once each", alice)
+			}
+			if bob := sto.GetTokenTransfers(BOB); len(bob) != 1 {
+				t.Errorf("bob got %d transfers, want only the one of block 9", len(bob))
+			}
+
+			// Nothing lands once the address is unsubscribed
+			sto.UnsubscribeAddress(BOB, true)
+			sto.StoreBackfillTransactions(BOB, BlockHeader{Number: 3}, nil, old)
+			if bob := sto.GetTokenTransfers(BOB); len(bob) != 0 {
+				t.Errorf("unsubscribed bob got %d transfers", len(bob))
+			}
+		})
+	}
+}
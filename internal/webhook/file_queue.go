@@ -0,0 +1,225 @@
+package webhook
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	QUEUE_PUSH  = "push"
+	QUEUE_RETRY = "retry"
+	QUEUE_ACK   = "ack"
+	QUEUE_DEAD  = "dead"
+)
+
+type (
+	// queueRecord is a single line of the queue log.
+	queueRecord struct {
+		Op       string    `json:"op"`
+		ID       string    `json:"id,omitempty"`
+		Delivery *Delivery `json:"delivery,omitempty"`
+	}
+
+	// fileQueue persists every queue change to an append-only log, mirrored
+	// by an in-memory queue. The log is compacted to the live deliveries
+	// each time it is opened.
+	fileQueue struct {
+		mu    sync.Mutex
+		path  string
+		file  *os.File
+		index *memoryQueue
+	}
+)
+
+// NewFileQueue opens (or creates) the queue log at `path` and replays it.
+func NewFileQueue(path string) (Queue, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create webhook queue dir: %w", err)
+	}
+
+	q := &fileQueue{path: path, index: newMemoryQueue()}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// replay applies every complete record of the log to the index. A torn or
+// corrupt trailing record is ignored; compaction drops it. A corrupt record
+// followed by others fails the replay rather than dropping them.
+func (q *fileQueue) replay() error {
+	file, err := os.Open(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open webhook queue: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				log.Printf("[WARN] Discarding incomplete webhook queue record at offset %d", offset)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read webhook queue: %w", err)
+		}
+
+		var rec queueRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			rest, readErr := io.ReadAll(reader)
+			if readErr != nil {
+				return fmt.Errorf("read webhook queue: %w", readErr)
+			}
+			if len(bytes.TrimSpace(rest)) > 0 {
+				return fmt.Errorf("corrupt webhook queue record at offset %d, followed by %d bytes of records: %w",
+					offset, len(rest), err)
+			}
+
+			log.Printf("[WARN] Discarding corrupt trailing webhook queue record at offset %d: %v", offset, err)
+			return nil
+		}
+
+		q.apply(rec)
+		offset += int64(len(line))
+	}
+}
+
+// apply mutates the index.
+func (q *fileQueue) apply(rec queueRecord) {
+	switch rec.Op {
+	case QUEUE_PUSH, QUEUE_RETRY:
+		q.index.Push(*rec.Delivery)
+	case QUEUE_ACK:
+		q.index.Ack(rec.ID)
+	case QUEUE_DEAD:
+		q.index.Dead(*rec.Delivery)
+	}
+}
+
+// compact rewrites the log with only the live deliveries, then reopens it
+// for appending.
+func (q *fileQueue) compact() error {
+	tmp := q.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("compact webhook queue: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	var records []queueRecord
+	for _, d := range q.index.Due(time.Unix(1<<62, 0)) {
+		d := d
+		records = append(records, queueRecord{Op: QUEUE_PUSH, Delivery: &d})
+	}
+	for _, d := range q.index.DeadLetters() {
+		d := d
+		records = append(records, queueRecord{Op: QUEUE_DEAD, Delivery: &d})
+	}
+
+	for _, rec := range records {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(append(data, '\n'))
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("compact webhook queue: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("compact webhook queue: %w", err)
+	}
+	file.Close()
+
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("compact webhook queue: %w", err)
+	}
+
+	q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open webhook queue: %w", err)
+	}
+
+	return nil
+}
+
+// append writes one record as a single line, fsyncs it and applies it.
+func (q *fileQueue) append(rec queueRecord) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := q.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("write webhook queue: %w", err)
+	}
+
+	if err := q.file.Sync(); err != nil {
+		return fmt.Errorf("sync webhook queue: %w", err)
+	}
+
+	q.apply(rec)
+
+	return nil
+}
+
+func (q *fileQueue) Push(d Delivery) error {
+	return q.append(queueRecord{Op: QUEUE_PUSH, Delivery: &d})
+}
+
+func (q *fileQueue) Retry(d Delivery) error {
+	return q.append(queueRecord{Op: QUEUE_RETRY, Delivery: &d})
+}
+
+func (q *fileQueue) Ack(id string) error {
+	return q.append(queueRecord{Op: QUEUE_ACK, ID: id})
+}
+
+func (q *fileQueue) Dead(d Delivery) error {
+	return q.append(queueRecord{Op: QUEUE_DEAD, Delivery: &d})
+}
+
+func (q *fileQueue) Due(now time.Time) []Delivery {
+	return q.index.Due(now)
+}
+
+func (q *fileQueue) DeadLetters() []Delivery {
+	return q.index.DeadLetters()
+}
+
+func (q *fileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.file.Close()
+}
@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// farFuture makes every pending delivery due.
+var farFuture = time.Unix(1<<62, 0)
+
+func TestFileQueueReplay(t *testing.T) {
+	push := func(id string) string {
+		return `{"op":"push","delivery":{"ID":"` + id + `","URL":"https://example.com"}}` + "\n"
+	}
+	ack := func(id string) string {
+		return `{"op":"ack","id":"` + id + `"}` + "\n"
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		pending int
+	}{
+		{"valid", push("a") + push("b") + ack("a"), false, 1},
+		{"torn tail", push("a") + `{"op":"pu`, false, 1},
+		{"corrupt last line", push("a") + push("b") + "not json\n", false, 2},
+		{"corrupt middle", push("a") + "not json\n" + push("b") + ack("a"), true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "webhooks.log")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			q, err := NewFileQueue(path)
+			if tt.wantErr {
+				if err == nil {
+					q.Close()
+					t.Fatal("expected an error for a corrupt record followed by valid ones")
+				}
+				if data, _ := os.ReadFile(path); string(data) != tt.content {
+					t.Error("the queue log was compacted despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer q.Close()
+
+			if got := len(q.Due(farFuture)); got != tt.pending {
+				t.Errorf("got %d pending deliveries, want %d", got, tt.pending)
+			}
+		})
+	}
+}
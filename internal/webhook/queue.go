@@ -0,0 +1,135 @@
+package webhook
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Delivery is one webhook POST and its retry state.
+	Delivery struct {
+		ID          string          `json:"id"`
+		Address     string          `json:"address"`
+		URL         string          `json:"url"`
+		Payload     json.RawMessage `json:"payload"`
+		Attempts    int             `json:"attempts"`
+		NextAttempt time.Time       `json:"nextAttempt"`
+		LastError   string          `json:"lastError,omitempty"`
+		CreatedAt   time.Time       `json:"createdAt"`
+	}
+
+	// Queue holds pending deliveries and the dead-letter list.
+	Queue interface {
+		// Push adds a new pending delivery.
+		Push(d Delivery) error
+		// Retry replaces a pending delivery after a failed attempt.
+		Retry(d Delivery) error
+		// Ack removes a delivered delivery.
+		Ack(id string) error
+		// Dead moves a delivery that ran out of attempts to the dead-letter list.
+		Dead(d Delivery) error
+		// Due returns the pending deliveries whose next attempt is at or before `now`.
+		Due(now time.Time) []Delivery
+		// DeadLetters returns the dead-letter list, oldest first.
+		DeadLetters() []Delivery
+		// Close releases any underlying resources.
+		Close() error
+	}
+
+	// memoryQueue is a Queue that doesn't survive restarts.
+	memoryQueue struct {
+		mu      sync.RWMutex
+		pending map[string]Delivery
+		dead    map[string]Delivery
+	}
+)
+
+// NewMemoryQueue returns an in-memory Queue.
+func NewMemoryQueue() Queue {
+	return newMemoryQueue()
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		pending: make(map[string]Delivery),
+		dead:    make(map[string]Delivery),
+	}
+}
+
+func (q *memoryQueue) Push(d Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending[d.ID] = d
+
+	return nil
+}
+
+func (q *memoryQueue) Retry(d Delivery) error {
+	return q.Push(d)
+}
+
+func (q *memoryQueue) Ack(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, id)
+
+	return nil
+}
+
+func (q *memoryQueue) Dead(d Delivery) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, d.ID)
+	q.dead[d.ID] = d
+
+	return nil
+}
+
+func (q *memoryQueue) Due(now time.Time) []Delivery {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	var due []Delivery
+	for _, d := range q.pending {
+		if !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+
+	sortByCreation(due)
+
+	return due
+}
+
+func (q *memoryQueue) DeadLetters() []Delivery {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	dead := make([]Delivery, 0, len(q.dead))
+	for _, d := range q.dead {
+		dead = append(dead, d)
+	}
+
+	sortByCreation(dead)
+
+	return dead
+}
+
+func (q *memoryQueue) Close() error {
+	return nil
+}
+
+// sortByCreation orders deliveries oldest first.
+func sortByCreation(deliveries []Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+}
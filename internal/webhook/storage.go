@@ -0,0 +1,103 @@
+package webhook
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+)
+
+// notifyingStorage queues a webhook delivery for every block that stores
+// transactions of a subscription with a webhook URL, and a removal for
+// every such block a reorg rolls back.
+type notifyingStorage struct {
+	storage.Storage
+	notifier *Notifier
+}
+
+// Wrap returns `sto` with StoreBlockTransactions and RollbackBlocks hooked
+// to `notifier`.
+func Wrap(sto storage.Storage, notifier *Notifier) storage.Storage {
+	return &notifyingStorage{Storage: sto, notifier: notifier}
+}
+
+// StoreBlockTransactions queues the deliveries, then stores the block. A
+// crash in between refetches the block and queues its deliveries again
+// under the same ids, where the reverse order would lose them. A failure to
+// queue fails the block, so it is retried.
+func (s *notifyingStorage) StoreBlockTransactions(header storage.BlockHeader, txs []storage.Transaction, transfers []storage.TokenTransfer) error {
+	for _, sub := range s.Storage.ListSubscriptions() {
+		if sub.WebhookURL == "" {
+			continue
+		}
+
+		matched := touching(sub.Address, txs)
+		if len(matched) == 0 {
+			continue
+		}
+
+		if err := s.notifier.Enqueue(sub, header, matched); err != nil {
+			return fmt.Errorf("queue webhook of block %d for %s: %w", header.Number, sub.Address, err)
+		}
+	}
+
+	return s.Storage.StoreBlockTransactions(header, txs, transfers)
+}
+
+// RollbackBlocks queues a removal of every stored block from `fromBlock` on
+// with transactions of a subscription with a webhook URL, then rolls them
+// back. Queuing first keeps the removals from being lost to a crash, and a
+// failure to queue fails the rollback, so it is retried.
+func (s *notifyingStorage) RollbackBlocks(fromBlock int) error {
+	for _, sub := range s.Storage.ListSubscriptions() {
+		if sub.WebhookURL == "" {
+			continue
+		}
+
+		for _, block := range byBlock(s.Storage.GetTransactions(sub.Address, storage.TxFilter{FromBlock: fromBlock})) {
+			if err := s.notifier.EnqueueRemoval(sub, block.header, block.txs); err != nil {
+				return fmt.Errorf("queue webhook removal of block %d for %s: %w", block.header.Number, sub.Address, err)
+			}
+		}
+	}
+
+	return s.Storage.RollbackBlocks(fromBlock)
+}
+
+// storedBlock is the header of a stored block and its TXs.
+type storedBlock struct {
+	header storage.BlockHeader
+	txs    []storage.Transaction
+}
+
+// byBlock groups `txs`, sorted by block, by block.
+func byBlock(txs []storage.Transaction) []storedBlock {
+	var blocks []storedBlock
+	for _, tx := range txs {
+		if len(blocks) == 0 || blocks[len(blocks)-1].header.Number != tx.BlockNumber {
+			header := storage.BlockHeader{Number: tx.BlockNumber}
+			if tx.Block != nil {
+				header = *tx.Block
+			}
+			blocks = append(blocks, storedBlock{header: header})
+		}
+
+		tx.Block = nil
+		last := &blocks[len(blocks)-1]
+		last.txs = append(last.txs, tx)
+	}
+
+	return blocks
+}
+
+// touching returns the TXs sent by, received by or creating `addr`.
+func touching(addr string, txs []storage.Transaction) []storage.Transaction {
+	var matched []storage.Transaction
+	for _, tx := range txs {
+		if strings.EqualFold(tx.From, addr) || strings.EqualFold(tx.To, addr) || strings.EqualFold(tx.ContractAddress, addr) {
+			matched = append(matched, tx)
+		}
+	}
+
+	return matched
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/pkg/logger"
+)
+
+const ALICE = "0x00000000000000000000000000000000000000aa"
+
+func TestRollbackQueuesRemovals(t *testing.T) {
+	queue := newMemoryQueue()
+	notifier := &Notifier{log: logger.NewLogger(), queue: queue, wake: make(chan struct{}, 1)}
+
+	sto := Wrap(storage.NewMemoryStorage(), notifier)
+	sto.SubscribeAddress(storage.Subscription{Address: ALICE, WebhookURL: "https://example.com/hook"})
+
+	for n := 1; n <= 3; n++ {
+		header := storage.BlockHeader{Number: n, Hash: fmt.Sprintf("0xh%d", n)}
+		tx := storage.Transaction{Hash: fmt.Sprintf("0xt%d", n), From: ALICE, BlockNumber: n, Value: "1"}
+		if err := sto.StoreBlockTransactions(header, []storage.Transaction{tx}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sto.RollbackBlocks(2); err != nil {
+		t.Fatal(err)
+	}
+
+	removed := map[int]string{}
+	for _, d := range queue.Due(farFuture) {
+		var p Payload
+		if err := json.Unmarshal(d.Payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		if !p.Removed {
+			continue
+		}
+		if len(p.Transactions) != 1 || p.Transactions[0].BlockNumber != p.Block.Number {
+			t.Errorf("removal of block %d carries %v", p.Block.Number, p.Transactions)
+		}
+		removed[p.Block.Number] = p.Block.Hash
+	}
+
+	if len(removed) != 2 || removed[2] != "0xh2" || removed[3] != "0xh3" {
+		t.Errorf("removals %v, want blocks 2 and 3 with their hashes", removed)
+	}
+	if len(queue.pending) != 5 {
+		t.Errorf("%d deliveries queued, want 3 blocks and 2 removals", len(queue.pending))
+	}
+}
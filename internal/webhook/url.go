@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// errForbiddenHost is returned for webhooks pointing at the server's own
+// network, unless WEBHOOK_ALLOW_PRIVATE is set.
+var errForbiddenHost = errors.New("webhook host is a loopback, link-local or private address")
+
+// CheckURL validates a webhook URL: http or https, with a host that isn't
+// on a loopback, link-local or private network unless WEBHOOK_ALLOW_PRIVATE
+// is set. Host names are resolved again when delivering, see newClient.
+func (n *Notifier) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported webhook scheme %q", u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("webhook URL has no host")
+	}
+
+	if n.allowPrivate {
+		return nil
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errForbiddenHost
+	}
+
+	if ip := net.ParseIP(host); ip != nil && forbiddenIP(ip) {
+		return errForbiddenHost
+	}
+
+	return nil
+}
+
+// forbiddenIP reports whether `ip` belongs to the server's own network.
+func forbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast()
+}
+
+// newClient returns the HTTP client deliveries are POSTed with. Unless
+// `allowPrivate`, it refuses to connect to forbidden addresses, which also
+// covers host names resolving to them and redirects.
+func newClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: REQUEST_TIMEOUT}
+	if !allowPrivate {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || forbiddenIP(ip) {
+				return errForbiddenHost
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy, only the proxy address would be checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{Timeout: REQUEST_TIMEOUT, Transport: transport}
+}
@@ -0,0 +1,55 @@
+package webhook
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url          string
+		allowPrivate bool
+		ok           bool
+	}{
+		{"https://hooks.example.com/eth", false, true},
+		{"http://93.184.216.34:8080/hook", false, true},
+		{"ftp://hooks.example.com", false, false},
+		{"https:///path", false, false},
+		{"http://localhost:3000", false, false},
+		{"http://api.localhost", false, false},
+		{"http://127.0.0.1", false, false},
+		{"http://[::1]:80", false, false},
+		{"http://169.254.169.254/latest/meta-data", false, false},
+		{"http://10.0.0.5", false, false},
+		{"http://192.168.1.1", false, false},
+		{"http://0.0.0.0", false, false},
+		{"http://127.0.0.1", true, true},
+		{"http://localhost:3000", true, true},
+	}
+
+	for _, tt := range tests {
+		n := &Notifier{allowPrivate: tt.allowPrivate}
+		if err := n.CheckURL(tt.url); (err == nil) != tt.ok {
+			t.Errorf("CheckURL(%q) with allowPrivate=%v = %v, want ok=%v", tt.url, tt.allowPrivate, err, tt.ok)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	for _, allowPrivate := range []bool{false, true} {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL, nil)
+
+		resp, err := newClient(allowPrivate).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err == nil) != allowPrivate {
+			t.Errorf("allowPrivate=%v: got error %v", allowPrivate, err)
+		}
+	}
+}
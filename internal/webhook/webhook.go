@@ -0,0 +1,288 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/buildwithme/ethparser/internal/storage"
+	"github.com/buildwithme/ethparser/pkg/constants"
+	"github.com/buildwithme/ethparser/pkg/env"
+	"github.com/buildwithme/ethparser/pkg/logger"
+)
+
+const (
+	HEADER_ID        = "X-Webhook-Id"
+	HEADER_TIMESTAMP = "X-Webhook-Timestamp"
+	HEADER_SIGNATURE = "X-Webhook-Signature"
+
+	POLL_INTERVAL   = time.Second
+	REQUEST_TIMEOUT = 10 * time.Second
+	BACKOFF_BASE    = 2 * time.Second
+	BACKOFF_MAX     = 10 * time.Minute
+	MAX_IN_FLIGHT   = 8
+)
+
+type (
+	// Payload is the JSON body POSTed for one block of one subscription.
+	// Removed is set when a reorg dropped the block and its transactions.
+	Payload struct {
+		ID           string                `json:"id"`
+		Address      string                `json:"address"`
+		Label        string                `json:"label,omitempty"`
+		Block        storage.BlockHeader   `json:"block"`
+		Transactions []storage.Transaction `json:"transactions"`
+		Removed      bool                  `json:"removed,omitempty"`
+	}
+
+	// Notifier queues webhook deliveries and dispatches them in the background.
+	Notifier struct {
+		log          *logger.Logger
+		queue        Queue
+		secret       []byte
+		maxAttempts  int
+		allowPrivate bool
+		client       *http.Client
+		wake         chan struct{}
+
+		mu       sync.Mutex
+		inFlight map[string]bool
+	}
+)
+
+// New constructs a Notifier. The delivery queue is kept in memory, or in
+// the WEBHOOK_QUEUE_PATH log when STORAGE=file.
+func New(log *logger.Logger) (*Notifier, error) {
+	secret := env.GetEnvString(constants.ENV_WEBHOOK_SECRET, "")
+	maxAttempts := env.GetEnvInt(constants.ENV_WEBHOOK_MAX_ATTEMPTS, 8)
+	allowPrivate := env.GetEnvBool(constants.ENV_WEBHOOK_ALLOW_PRIVATE, false)
+
+	queue := NewMemoryQueue()
+	if env.GetEnvString(constants.ENV_STORAGE, storage.BACKEND_MEMORY) == storage.BACKEND_FILE {
+		var err error
+		queue, err = NewFileQueue(env.GetEnvString(constants.ENV_WEBHOOK_QUEUE_PATH, "data/webhooks.log"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if secret == "" {
+		log.Printf("[WARN] WEBHOOK_SECRET is not set; webhook payloads will not be signed")
+	}
+
+	return &Notifier{
+		log:          log,
+		queue:        queue,
+		secret:       []byte(secret),
+		maxAttempts:  maxAttempts,
+		allowPrivate: allowPrivate,
+		client:       newClient(allowPrivate),
+		wake:         make(chan struct{}, 1),
+		inFlight:     make(map[string]bool),
+	}, nil
+}
+
+// Enqueue queues one delivery of `txs` to the webhook of `sub`. The
+// delivery id is derived from the address and block hash, so receivers can
+// drop duplicates.
+func (n *Notifier) Enqueue(sub storage.Subscription, header storage.BlockHeader, txs []storage.Transaction) error {
+	return n.enqueue(sub, header, txs, false)
+}
+
+// EnqueueRemoval queues a delivery telling the webhook of `sub` that a
+// reorg dropped the block of `header` and its `txs`.
+func (n *Notifier) EnqueueRemoval(sub storage.Subscription, header storage.BlockHeader, txs []storage.Transaction) error {
+	return n.enqueue(sub, header, txs, true)
+}
+
+func (n *Notifier) enqueue(sub storage.Subscription, header storage.BlockHeader, txs []storage.Transaction, removed bool) error {
+	key := fmt.Sprintf("%s:%d:%s", sub.Address, header.Number, header.Hash)
+	if removed {
+		key += ":removed"
+	}
+	sum := sha256.Sum256([]byte(key))
+	id := hex.EncodeToString(sum[:16])
+
+	payload, err := json.Marshal(Payload{
+		ID:           id,
+		Address:      sub.Address,
+		Label:        sub.Label,
+		Block:        header,
+		Transactions: txs,
+		Removed:      removed,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = n.queue.Push(Delivery{
+		ID:          id,
+		Address:     sub.Address,
+		URL:         sub.WebhookURL,
+		Payload:     payload,
+		NextAttempt: now,
+		CreatedAt:   now,
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// DeadLetters returns the deliveries that ran out of attempts, oldest first.
+func (n *Notifier) DeadLetters() []Delivery {
+	return n.queue.DeadLetters()
+}
+
+// Run dispatches due deliveries until `ctx` is done.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(POLL_INTERVAL)
+	defer ticker.Stop()
+
+	sem := make(chan struct{}, MAX_IN_FLIGHT)
+	for {
+		for _, d := range n.queue.Due(time.Now()) {
+			if !n.claim(d.ID) {
+				continue
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func(d Delivery) {
+				defer func() { <-sem }()
+				defer n.release(d.ID)
+				n.attempt(ctx, d)
+			}(d)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// Close releases the delivery queue.
+func (n *Notifier) Close() error {
+	return n.queue.Close()
+}
+
+// claim marks a delivery as in flight, reporting false if it already was.
+func (n *Notifier) claim(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.inFlight[id] {
+		return false
+	}
+	n.inFlight[id] = true
+
+	return true
+}
+
+func (n *Notifier) release(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	delete(n.inFlight, id)
+}
+
+// attempt POSTs a delivery once, then acks it, schedules a retry with
+// exponential backoff, or dead-letters it after WEBHOOK_MAX_ATTEMPTS.
+func (n *Notifier) attempt(ctx context.Context, d Delivery) {
+	err := n.post(ctx, d)
+	if ctx.Err() != nil {
+		// Shutting down: leave the delivery due for the next run.
+		return
+	}
+
+	d.Attempts++
+	if err == nil {
+		if err := n.queue.Ack(d.ID); err != nil {
+			n.log.Printf("[ERROR] Failed to ack webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= n.maxAttempts {
+		n.log.Printf("[WARN] Webhook delivery %s to %s dead-lettered after %d attempts: %v", d.ID, d.URL, d.Attempts, err)
+		if err := n.queue.Dead(d); err != nil {
+			n.log.Printf("[ERROR] Failed to dead-letter webhook delivery %s: %v", d.ID, err)
+		}
+		return
+	}
+
+	backoff := BACKOFF_BASE << (d.Attempts - 1)
+	if backoff > BACKOFF_MAX || backoff <= 0 {
+		backoff = BACKOFF_MAX
+	}
+	d.NextAttempt = time.Now().UTC().Add(backoff)
+
+	n.log.Printf("[WARN] Webhook delivery %s to %s attempt %d failed: %v. Retrying in %v", d.ID, d.URL, d.Attempts, err, backoff)
+	if err := n.queue.Retry(d); err != nil {
+		n.log.Printf("[ERROR] Failed to reschedule webhook delivery %s: %v", d.ID, err)
+	}
+}
+
+// post sends the payload of `d`; any non-2xx response is an error.
+func (n *Notifier) post(ctx context.Context, d Delivery) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_ID, d.ID)
+	req.Header.Set(HEADER_TIMESTAMP, timestamp)
+	if len(n.secret) > 0 {
+		req.Header.Set(HEADER_SIGNATURE, Sign(n.secret, timestamp, d.Payload))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// Sign returns the signature header value of a payload: the hex HMAC-SHA256
+// of "<timestamp>.<body>" keyed by `secret`, prefixed with "sha256=".
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,13 +1,33 @@
 package constants
 
 const (
-	ENV_PORT                = "PORT"
-	ENV_FILE_PATH           = "ENV_FILE_PATH"
-	ENV_ADDRESSES           = "ADDRESSES"
-	ENV_RPC_ENDPOINT        = "RPC_ENDPOINT"
-	ENV_CONCURRENCY         = "CONCURRENCY"
-	ENV_CHUNK_SIZE          = "CHUNK_SIZE"
-	ENV_MAX_RETRIES         = "MAX_RETRIES"
-	ENV_DEFAULT_START_BLOCK = "DEFAULT_START_BLOCK"
-	ENV_DEFAULT_END_BLOCK   = "DEFAULT_END_BLOCK"
+	ENV_PORT                  = "PORT"
+	ENV_FILE_PATH             = "ENV_FILE_PATH"
+	ENV_ADDRESSES             = "ADDRESSES"
+	ENV_RPC_ENDPOINT          = "RPC_ENDPOINT"
+	ENV_RPC_STRATEGY          = "RPC_STRATEGY"
+	ENV_RPC_MAX_LAG           = "RPC_MAX_LAG"
+	ENV_RPC_RATE_LIMIT        = "RPC_RATE_LIMIT"
+	ENV_RPC_RATE_BURST        = "RPC_RATE_BURST"
+	ENV_CONCURRENCY           = "CONCURRENCY"
+	ENV_MAX_CONCURRENCY       = "MAX_CONCURRENCY"
+	ENV_CHUNK_SIZE            = "CHUNK_SIZE"
+	ENV_MAX_RETRIES           = "MAX_RETRIES"
+	ENV_BATCH_SIZE            = "BATCH_SIZE"
+	ENV_FETCH_RECEIPTS        = "FETCH_RECEIPTS"
+	ENV_FETCH_TOKEN_TRANSFERS = "FETCH_TOKEN_TRANSFERS"
+	ENV_FETCH_TRACES          = "FETCH_TRACES"
+	ENV_DEFAULT_START_BLOCK   = "DEFAULT_START_BLOCK"
+	ENV_DEFAULT_END_BLOCK     = "DEFAULT_END_BLOCK"
+	ENV_STORAGE               = "STORAGE"
+	ENV_STORAGE_PATH          = "STORAGE_PATH"
+	ENV_CONFIRMATIONS         = "CONFIRMATIONS"
+	ENV_RETAIN_UNSUBSCRIBED   = "RETAIN_UNSUBSCRIBED"
+	ENV_WEBHOOK_SECRET        = "WEBHOOK_SECRET"
+	ENV_WEBHOOK_QUEUE_PATH    = "WEBHOOK_QUEUE_PATH"
+	ENV_WEBHOOK_MAX_ATTEMPTS  = "WEBHOOK_MAX_ATTEMPTS"
+	ENV_WEBHOOK_ALLOW_PRIVATE = "WEBHOOK_ALLOW_PRIVATE"
+	ENV_READY_MAX_LAG         = "READY_MAX_LAG"
+	ENV_BACKFILL_MAX_BLOCKS   = "BACKFILL_MAX_BLOCKS"
+	ENV_WS_ALLOWED_ORIGINS    = "WS_ALLOWED_ORIGINS"
 )
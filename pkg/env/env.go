@@ -69,6 +69,20 @@ func GetEnvInt(key string, def int) int {
 	return i
 }
 
+func GetEnvBool(key string, def bool) bool {
+	val := os.Getenv(key)
+	if val == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return def
+	}
+
+	return b
+}
+
 func GetEnvString(key string, def string) string {
 	val := os.Getenv(key)
 	if val == "" {
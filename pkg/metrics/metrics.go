@@ -0,0 +1,325 @@
+// Package metrics is a minimal Prometheus instrumentation library: labelled
+// counters, gauges and histograms rendered in the text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+
+	// CONTENT_TYPE is the Prometheus text exposition format.
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// DEFAULT_BUCKETS are latency buckets in seconds.
+var DEFAULT_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry the package-level constructors register into.
+var Default = NewRegistry()
+
+type (
+	// Registry holds metric families and renders them.
+	Registry struct {
+		mu       sync.Mutex
+		families map[string]family
+	}
+
+	// family is one metric name with all its label combinations.
+	family interface {
+		write(w io.Writer)
+	}
+
+	// vec is the label bookkeeping shared by every metric type.
+	vec struct {
+		name   string
+		help   string
+		kind   string
+		labels []string
+
+		mu     sync.Mutex
+		series map[string]*series
+	}
+
+	series struct {
+		labelValues []string
+		value       float64   // counter and gauge
+		buckets     []uint64  // histogram, per bucket (not cumulative)
+		sum         float64   // histogram
+		count       uint64    // histogram
+		bounds      []float64 // histogram upper bounds
+	}
+
+	// CounterVec is a family of counters partitioned by labels.
+	CounterVec struct{ *vec }
+
+	// GaugeVec is a family of gauges partitioned by labels.
+	GaugeVec struct{ *vec }
+
+	// HistogramVec is a family of histograms partitioned by labels.
+	HistogramVec struct {
+		*vec
+		bounds []float64
+	}
+)
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]family)}
+}
+
+// NewCounterVec registers a counter family in Default.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGaugeVec registers a gauge family in Default.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// NewHistogramVec registers a histogram family in Default. Nil `buckets`
+// means DEFAULT_BUCKETS.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// NewCounterVec registers a counter family.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, TYPE_COUNTER, labels, nil)}
+	r.register(name, c)
+	return c
+}
+
+// NewGaugeVec registers a gauge family.
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(name, help, TYPE_GAUGE, labels, nil)}
+	r.register(name, g)
+	return g
+}
+
+// NewHistogramVec registers a histogram family with ascending `buckets`
+// upper bounds (DEFAULT_BUCKETS if nil).
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DEFAULT_BUCKETS
+	}
+
+	h := &HistogramVec{vec: newVec(name, help, TYPE_HISTOGRAM, labels, buckets), bounds: buckets}
+	r.register(name, h)
+	return h
+}
+
+// register adds a family, panicking on a duplicate name like a Prometheus
+// client would: it is a programming error.
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.families[name]; ok {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+
+	r.families[name] = f
+}
+
+// Write renders every family in the text exposition format, sorted by name.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := r.families
+	r.mu.Unlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		families[name].write(w)
+	}
+}
+
+// Handler serves the registry on GET.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", CONTENT_TYPE)
+		r.Write(w)
+	})
+}
+
+// newVec creates a family. A family without labels has a single series,
+// exposed as zero until first updated.
+func newVec(name, help, kind string, labels []string, bounds []float64) *vec {
+	v := &vec{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+	if len(labels) == 0 {
+		v.get(nil, bounds)
+	}
+
+	return v
+}
+
+// get returns the series of `labelValues`, creating it. Caller must hold v.mu.
+func (v *vec) get(labelValues []string, bounds []float64) *series {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...), bounds: bounds}
+		if bounds != nil {
+			s.buckets = make([]uint64, len(bounds))
+		}
+		v.series[key] = s
+	}
+
+	return s
+}
+
+// Add increases the counter of `labelValues` by `delta` (must be >= 0).
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.get(labelValues, nil).value += delta
+}
+
+// Inc increases the counter of `labelValues` by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set sets the gauge of `labelValues`.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labelValues, nil).value = value
+}
+
+// Add changes the gauge of `labelValues` by `delta`.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.get(labelValues, nil).value += delta
+}
+
+// Observe records `value` in the histogram of `labelValues`.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(labelValues, h.bounds)
+	for i, bound := range s.bounds {
+		if value <= bound {
+			s.buckets[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// write renders the family. The series are sorted by label values so the
+// output is stable between scrapes.
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, escapeHelp(v.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := v.series[key]
+		labels := formatLabels(v.labels, s.labelValues)
+
+		if v.kind != TYPE_HISTOGRAM {
+			fmt.Fprintf(w, "%s%s %s\n", v.name, wrap(labels), formatValue(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range s.bounds {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, wrap(join(labels, `le="`+formatValue(bound)+`"`)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, wrap(join(labels, `le="+Inf"`)), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, wrap(labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, wrap(labels), s.count)
+	}
+}
+
+// formatLabels renders `name="value"` pairs, comma-separated.
+func formatLabels(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func join(labels, extra string) string {
+	if labels == "" {
+		return extra
+	}
+
+	return labels + "," + extra
+}
+
+func wrap(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
@@ -0,0 +1,214 @@
+// Package websocket is a minimal RFC 6455 implementation covering what the
+// parser needs: text/binary messages, ping/pong and close.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	OPCODE_CONTINUATION = 0x0
+	OPCODE_TEXT         = 0x1
+	OPCODE_BINARY       = 0x2
+	OPCODE_CLOSE        = 0x8
+	OPCODE_PING         = 0x9
+	OPCODE_PONG         = 0xA
+
+	// MAX_MESSAGE_SIZE bounds a single (possibly fragmented) message read
+	// by a client, sized for large upstream RPC responses.
+	MAX_MESSAGE_SIZE = 64 << 20
+
+	// MAX_CLIENT_MESSAGE_SIZE bounds the messages a server reads from its
+	// clients, which only send small requests.
+	MAX_CLIENT_MESSAGE_SIZE = 8 << 10
+)
+
+var (
+	// ErrClosed is returned once the peer has closed the connection.
+	ErrClosed = errors.New("websocket: connection closed")
+
+	// ErrUnmaskedFrame is returned by a server reading a frame its client
+	// didn't mask, which RFC 6455 section 5.1 forbids.
+	ErrUnmaskedFrame = errors.New("websocket: unmasked client frame")
+)
+
+// Conn is a WebSocket connection. Reads must come from a single goroutine;
+// writes are safe for concurrent use.
+type Conn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	client  bool // clients mask every frame they send
+	limit   int  // largest message ReadMessage accepts
+	writeMu sync.Mutex
+}
+
+// ReadMessage returns the payload of the next text or binary message,
+// answering pings and reassembling fragments along the way.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case OPCODE_PING:
+			if err := c.writeFrame(OPCODE_PONG, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case OPCODE_PONG:
+			continue
+		case OPCODE_CLOSE:
+			_ = c.writeFrame(OPCODE_CLOSE, nil)
+			return nil, ErrClosed
+		}
+
+		message = append(message, payload...)
+		if len(message) > c.limit {
+			return nil, fmt.Errorf("websocket: message exceeds %d bytes", c.limit)
+		}
+
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// WriteMessage sends `data` as a single text message.
+func (c *Conn) WriteMessage(data []byte) error {
+	return c.writeFrame(OPCODE_TEXT, data)
+}
+
+// Ping sends a ping control frame.
+func (c *Conn) Ping() error {
+	return c.writeFrame(OPCODE_PING, nil)
+}
+
+// SetReadLimit sets the largest message ReadMessage accepts.
+func (c *Conn) SetReadLimit(limit int) {
+	c.limit = limit
+}
+
+// SetReadDeadline sets the deadline for future ReadMessage calls.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *Conn) Close() error {
+	_ = c.writeFrame(OPCODE_CLOSE, nil)
+	return c.conn.Close()
+}
+
+// readFrame reads a single frame and unmasks its payload. The payload is
+// only allocated once its length is known to fit the read limit, and a
+// server only reads masked frames.
+func (c *Conn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if !c.client && !masked {
+		return false, 0, nil, ErrUnmaskedFrame
+	}
+
+	if length > uint64(c.limit) {
+		return false, 0, nil, fmt.Errorf("websocket: frame exceeds %d bytes", c.limit)
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+// writeFrame writes a single unfragmented frame, masking it on the client side.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		frame = append(frame, mask[:]...)
+
+		start := len(frame)
+		frame = append(frame, payload...)
+		for i := range payload {
+			frame[start+i] ^= mask[i%4]
+		}
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	_, err := c.conn.Write(frame)
+	return err
+}
@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// pipe connects a client and a server Conn in memory.
+func pipe(t *testing.T) (*Conn, *Conn) {
+	t.Helper()
+
+	c, s := net.Pipe()
+	t.Cleanup(func() { c.Close(); s.Close() })
+
+	client := &Conn{conn: c, br: bufio.NewReader(c), client: true, limit: MAX_MESSAGE_SIZE}
+	server := &Conn{conn: s, br: bufio.NewReader(s), limit: MAX_MESSAGE_SIZE}
+
+	return client, server
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"empty", 0},
+		{"7-bit length", 125},
+		{"16-bit length", 126},
+		{"16-bit max", 0xFFFF},
+		{"64-bit length", 0x10000},
+	}
+
+	for _, tt := range sizes {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := pipe(t)
+			payload := bytes.Repeat([]byte("x"), tt.size)
+
+			// Both directions: client frames are masked, server frames aren't
+			for _, dir := range []struct {
+				from, to *Conn
+			}{{client, server}, {server, client}} {
+				errs := make(chan error, 1)
+				go func() { errs <- dir.from.WriteMessage(payload) }()
+
+				got, err := dir.to.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, payload) {
+					t.Errorf("got %d bytes, want %d", len(got), len(payload))
+				}
+			}
+		})
+	}
+}
+
+func TestReadMessageFragmented(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	client := &Conn{conn: s, br: bufio.NewReader(s), client: true, limit: MAX_MESSAGE_SIZE}
+
+	// "hel" (text, not fin) + ping + "lo" (continuation, fin), unmasked
+	// as a server sends them
+	frames := []byte{0x01, 3, 'h', 'e', 'l', 0x89, 0, 0x80, 2, 'l', 'o'}
+	go func() {
+		c.Write(frames)
+		// Read the masked pong answering the ping
+		var pong [6]byte
+		io.ReadFull(c, pong[:])
+	}()
+
+	got, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		server bool
+		input  []byte
+	}{
+		{"truncated header", false, []byte{0x81}},
+		{"truncated payload", false, []byte{0x81, 5, 'a'}},
+		{"truncated extended length", false, []byte{0x81, 126, 0}},
+		{"oversized frame", false, []byte{0x81, 127, 0xFF, 0, 0, 0, 0, 0, 0, 0}},
+		{"unmasked client frame", true, []byte{0x81, 1, 'a'}},
+		{"client frame over the server limit", true, []byte{0x81, 0x80 | 127, 0, 0, 0, 0, 0, 1, 0, 0, 1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &Conn{br: bufio.NewReader(bytes.NewReader(tt.input)), client: !tt.server, limit: MAX_MESSAGE_SIZE}
+			if tt.server {
+				conn.limit = MAX_CLIENT_MESSAGE_SIZE
+			}
+
+			if _, _, _, err := conn.readFrame(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestReadMessageClose(t *testing.T) {
+	client, server := pipe(t)
+
+	go client.Close()
+
+	if _, err := server.ReadMessage(); !errors.Is(err, ErrClosed) {
+		t.Errorf("got %v, want ErrClosed", err)
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// Example from RFC 6455, section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("got %q", got)
+	}
+}